@@ -0,0 +1,255 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/config"
+	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/metadata"
+	"github.com/xuxinlai2002/creda-celo-balance/snapshot"
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+// Auditor compares the balances computed by the statistics job for one day
+// with the balances on chain at the last block of that day.
+type Auditor struct {
+	cfg      *config.Config
+	cli      *client.Client
+	db       *db.PostgresDB
+	snap     *snapshot.Snapshotter
+	decimals map[types.COINID]uint8
+}
+
+func New(cfg *config.Config) (*Auditor, error) {
+	cli, err := client.DialConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	database, err := db.NewDB(cfg.PostgresDBName, cfg.PostgresUser, cfg.PostgresPassword, cfg.PostgresHost, cfg.PostgresPort)
+	if err != nil {
+		cli.Close()
+		return nil, errors.New(fmt.Sprintf("new db err: %v", err))
+	}
+	a := &Auditor{cfg: cfg, cli: cli, db: database}
+	if a.decimals, err = metadata.LoadDecimals(database); err != nil {
+		a.Close()
+		return nil, err
+	}
+	// The snapshotter reads through the connections of the auditor.
+	if a.snap, err = snapshot.NewWith(cfg, cli, database); err != nil {
+		a.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// Close releases the connections of the auditor and its snapshotter.
+func (a *Auditor) Close() {
+	a.db.Close()
+	a.cli.Close()
+}
+
+// Run audits the accounts of the ods_balance run of AuditDate, a random
+// sample of AuditSample of them or all of them if it is 0, and writes the
+// discrepancies to AuditReport. The connections are closed when it returns.
+func (a *Auditor) Run(ctx context.Context) error {
+	defer a.Close()
+	day, err := time.ParseInLocation("2006-01-02", a.cfg.AuditDate, time.Local)
+	if err != nil {
+		return errors.New("AuditDate time format error " + err.Error())
+	}
+	suffix := day.Format("20060102")
+	accounts, err := a.db.ReadBalanceAddresses("ods_balance_" + suffix)
+	if err != nil {
+		return err
+	}
+	accounts = sample(accounts, a.cfg.AuditSample, rand.New(rand.NewSource(time.Now().UnixNano())))
+	computed, err := a.db.ReadCoinBalances("ods_coin_balance_"+suffix, accounts)
+	if err != nil {
+		return err
+	}
+
+	// Record tables are named after the local date of a block.
+	number, err := lastBlockBefore(ctx, a.cli, uint64(day.AddDate(0, 0, 1).Unix()))
+	if err != nil {
+		return err
+	}
+	onChain, err := a.snap.Take(ctx, number, accounts)
+	if err != nil {
+		return err
+	}
+
+	discrepancies := compare(computed, onChain, a.decimals)
+	log.Infof("audited %v accounts of %v at block %v: %v discrepancies", len(accounts), a.cfg.AuditDate, number, len(discrepancies))
+	report := a.cfg.AuditReport
+	if report == "" {
+		report = "audit_" + suffix + ".csv"
+	}
+	return writeReport(report, discrepancies)
+}
+
+// sample returns n accounts picked at random, all of them if n is 0 or
+// exceeds their number.
+func sample(accounts []common.Address, n int, rnd *rand.Rand) []common.Address {
+	if n <= 0 || n >= len(accounts) {
+		return accounts
+	}
+	picked := make([]common.Address, n)
+	for i, j := range rnd.Perm(len(accounts))[:n] {
+		picked[i] = accounts[j]
+	}
+	return picked
+}
+
+// lastBlockBefore returns the last block with a timestamp before end, found
+// by bisecting the chain.
+func lastBlockBefore(ctx context.Context, cli *client.Client, end uint64) (uint64, error) {
+	head, err := cli.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	if head.Time < end {
+		return 0, errors.New(fmt.Sprintf("the chain head %v is before the end of the day", head.Number))
+	}
+	genesis, err := cli.HeaderByNumber(ctx, big.NewInt(0))
+	if err != nil {
+		return 0, err
+	}
+	if genesis.Time >= end {
+		return 0, errors.New("the day ends before genesis")
+	}
+	// The block lo is before end and the block hi is not.
+	lo, hi := uint64(0), head.Number.Uint64()
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		header, err := cli.HeaderByNumber(ctx, new(big.Int).SetUint64(mid))
+		if err != nil {
+			return 0, err
+		}
+		if header.Time < end {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// discrepancy is a balance computed by the statistics job that differs from
+// the balance on chain.
+type discrepancy struct {
+	address  common.Address
+	coinID   types.COINID
+	computed *big.Int
+	onChain  *big.Int
+	diff     *big.Int   // computed - onChain
+	units    *big.Float // |diff| in units of the coin
+}
+
+// compare returns the discrepancies between the computed balances and the
+// balances on chain, the biggest in units of their coin first. Tokens
+// sharing a coin id are summed up. Accounts missing on either side hold
+// zero.
+func compare(computed map[common.Address]map[types.COINID]*big.Int, onChain []*types.Balance, decimals map[types.COINID]uint8) []*discrepancy {
+	type key struct {
+		address common.Address
+		coinID  types.COINID
+	}
+	chain := make(map[key]*big.Int)
+	for _, b := range onChain {
+		k := key{b.Address, types.COINID(b.CoinID)}
+		if chain[k] == nil {
+			chain[k] = big.NewInt(0)
+		}
+		chain[k].Add(chain[k], b.Value)
+	}
+	keys := make(map[key]bool, len(chain))
+	for k := range chain {
+		keys[k] = true
+	}
+	for address, coins := range computed {
+		for coinID := range coins {
+			keys[key{address, coinID}] = true
+		}
+	}
+
+	discrepancies := make([]*discrepancy, 0)
+	for k := range keys {
+		c, o := computed[k.address][k.coinID], chain[k]
+		if c == nil {
+			c = big.NewInt(0)
+		}
+		if o == nil {
+			o = big.NewInt(0)
+		}
+		diff := new(big.Int).Sub(c, o)
+		if diff.Sign() == 0 {
+			continue
+		}
+		units := new(big.Float).SetInt(new(big.Int).Abs(diff))
+		units.Quo(units, new(big.Float).SetFloat64(math.Pow(10, float64(decimals[k.coinID]))))
+		discrepancies = append(discrepancies, &discrepancy{
+			address:  k.address,
+			coinID:   k.coinID,
+			computed: c,
+			onChain:  o,
+			diff:     diff,
+			units:    units,
+		})
+	}
+	sort.Slice(discrepancies, func(i, j int) bool {
+		if c := discrepancies[i].units.Cmp(discrepancies[j].units); c != 0 {
+			return c > 0
+		}
+		if discrepancies[i].coinID != discrepancies[j].coinID {
+			return discrepancies[i].coinID < discrepancies[j].coinID
+		}
+		return bytes.Compare(discrepancies[i].address[:], discrepancies[j].address[:]) < 0
+	})
+	return discrepancies
+}
+
+// writeReport writes the discrepancies as CSV to path, amounts in the
+// smallest unit of their coin.
+func writeReport(path string, discrepancies []*discrepancy) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	if err := w.Write([]string{"address", "coinid", "computed", "onchain", "diff", "diffunits"}); err != nil {
+		return err
+	}
+	for _, d := range discrepancies {
+		err := w.Write([]string{
+			d.address.String(),
+			fmt.Sprint(d.coinID),
+			d.computed.String(),
+			d.onChain.String(),
+			d.diff.String(),
+			d.units.Text('f', 18),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
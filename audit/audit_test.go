@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/client/mocknode"
+	"github.com/xuxinlai2002/creda-celo-balance/config"
+	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/db/dbtest"
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+var (
+	alice = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	bob   = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+	carol = common.HexToAddress("0x00000000000000000000000000000000000ca201")
+
+	cUSD = common.HexToAddress("0x765DE816845861e75A25fCA122bb6898B8B1282a")
+	cEUR = common.HexToAddress("0xD8763CBa276a3738E6DE85b4b3bF5FDed6D6cA73")
+)
+
+const cUSDCoinID = 7236
+
+func ether(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), big.NewInt(1e18))
+}
+
+// TestCompare tests that balances differing from the chain are reported in
+// units of their coin, the biggest first, with tokens of one coin summed up
+// and missing balances taken as zero.
+func TestCompare(t *testing.T) {
+	computed := map[common.Address]map[types.COINID]*big.Int{
+		alice: {types.CELO_COINID: ether(10), cUSDCoinID: ether(5)},
+		bob:   {types.CELO_COINID: ether(3)},
+	}
+	onChain := []*types.Balance{
+		{Address: alice, Token: common.ZeroAddress, CoinID: types.CELO_COINID, Value: ether(10)},
+		{Address: alice, Token: cUSD, CoinID: cUSDCoinID, Value: ether(2)},
+		{Address: alice, Token: cEUR, CoinID: cUSDCoinID, Value: ether(1)},
+		{Address: carol, Token: cUSD, CoinID: cUSDCoinID, Value: ether(7)},
+	}
+	decimals := map[types.COINID]uint8{types.CELO_COINID: 18, cUSDCoinID: 18}
+
+	discrepancies := compare(computed, onChain, decimals)
+	type row struct {
+		address common.Address
+		coinID  types.COINID
+		diff    string
+	}
+	var got []row
+	for _, d := range discrepancies {
+		got = append(got, row{d.address, d.coinID, d.diff.String()})
+	}
+	require.Equal(t, []row{
+		{carol, cUSDCoinID, "-7000000000000000000"},
+		{bob, types.CELO_COINID, "3000000000000000000"},
+		{alice, cUSDCoinID, "2000000000000000000"},
+	}, got)
+
+	path := filepath.Join(t.TempDir(), "audit.csv")
+	require.NoError(t, writeReport(path, discrepancies))
+	report, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(report)), "\n")
+	require.Len(t, lines, 4)
+	require.Equal(t, "address,coinid,computed,onchain,diff,diffunits", lines[0])
+	require.Equal(t, carol.String()+",7236,0,7000000000000000000,-7000000000000000000,7.000000000000000000", lines[1])
+}
+
+// TestLastBlockBefore tests that the last block of a day is found by its
+// timestamp.
+func TestLastBlockBefore(t *testing.T) {
+	node := mocknode.New(1000)
+	defer node.Close()
+	for _, time := range []uint64{1005, 1010, 1015, 1020, 1025} {
+		node.AddBlock(time)
+	}
+	cli, err := client.Dial(node.URL)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	for end, want := range map[uint64]uint64{1001: 0, 1010: 1, 1011: 2, 1025: 4} {
+		number, err := lastBlockBefore(context.Background(), cli, end)
+		require.NoError(t, err)
+		require.Equal(t, want, number, "end %v", end)
+	}
+	_, err = lastBlockBefore(context.Background(), cli, 1026)
+	require.Error(t, err)
+	_, err = lastBlockBefore(context.Background(), cli, 1000)
+	require.Error(t, err)
+}
+
+// TestSample tests that a sample holds distinct accounts of the run.
+func TestSample(t *testing.T) {
+	accounts := []common.Address{alice, bob, carol}
+	require.Equal(t, accounts, sample(accounts, 0, rand.New(rand.NewSource(1))))
+	require.Equal(t, accounts, sample(accounts, 5, rand.New(rand.NewSource(1))))
+
+	picked := sample(accounts, 2, rand.New(rand.NewSource(1)))
+	require.Len(t, picked, 2)
+	require.NotEqual(t, picked[0], picked[1])
+	require.Subset(t, accounts, picked)
+}
+
+// TestRunCloses tests that the auditor and the snapshotter it reads through
+// are released when a run returns.
+func TestRunCloses(t *testing.T) {
+	node := mocknode.New(1000)
+	defer node.Close()
+	registry := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(registry,
+		[]byte(`[{"address": "`+cUSD.Hex()+`", "name": "cUSD", "coinId": 7236, "decimals": 18}]`), 0644))
+	cfg := &config.Config{HTTP: node.URL, PostgresDBName: "creda", PostgresHost: dbtest.Use(t), TokenRegistry: registry, AuditDate: "May 1st"}
+	database, err := db.NewDB(cfg.PostgresDBName, "", "", cfg.PostgresHost, 5432)
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.CreateTokenTable())
+	require.NoError(t, database.UpsertTokens([]*types.Token{{Address: cUSD, Name: "cUSD", CoinID: cUSDCoinID, Decimals: 18}}))
+
+	a, err := New(cfg)
+	require.NoError(t, err)
+	require.Error(t, a.Run(context.Background()))
+	_, err = a.db.ReadTokens()
+	require.ErrorContains(t, err, "database is closed")
+}
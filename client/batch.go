@@ -0,0 +1,334 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/celo-org/celo-blockchain"
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/common/hexutil"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/celo-org/celo-blockchain/eth/tracers"
+	"github.com/celo-org/celo-blockchain/rpc"
+)
+
+// maxBatchSize is the largest number of calls sent in one JSON-RPC batch.
+// Most hosted providers reject batches above a few hundred elements.
+const maxBatchSize = 100
+
+// batchCall sends the elements in chunks of maxBatchSize. A transport error
+// of a chunk is recorded on every element of that chunk, so callers only need
+// to look at BatchElem.Error. Archive batches only use archive endpoints.
+func (c *Client) batchCall(ctx context.Context, archive bool, elems []rpc.BatchElem) {
+	for start := 0; start < len(elems); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(elems) {
+			end = len(elems)
+		}
+		chunk := elems[start:end]
+		err := c.pool.call(ctx, archive, len(chunk), func(rpcClient *rpc.Client) error {
+			for i := range chunk {
+				chunk[i].Error = nil
+			}
+			if err := rpcClient.BatchCallContext(ctx, chunk); err != nil {
+				return err
+			}
+			// Providers may rate limit single elements of a batch, retry
+			// the chunk then.
+			for i := range chunk {
+				if isRateLimited(chunk[i].Error) {
+					return chunk[i].Error
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			for i := range chunk {
+				chunk[i].Error = err
+			}
+		}
+	}
+}
+
+// BlocksByNumber fetches the given blocks with full transactions in batched
+// requests. Blocks and errors are returned in the order of numbers.
+func (c *Client) BlocksByNumber(ctx context.Context, numbers []*big.Int) ([]*types.Block, []error) {
+	raws := make([]json.RawMessage, len(numbers))
+	elems := make([]rpc.BatchElem, len(numbers))
+	for i, number := range numbers {
+		elems[i] = rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{toBlockNumArg(number), true},
+			Result: &raws[i],
+		}
+	}
+	c.batchCall(ctx, false, elems)
+
+	blocks := make([]*types.Block, len(numbers))
+	errs := make([]error, len(numbers))
+	for i := range elems {
+		if elems[i].Error != nil {
+			errs[i] = elems[i].Error
+			continue
+		}
+		blocks[i], errs[i] = decodeBlock(raws[i])
+	}
+	return blocks, errs
+}
+
+// HeadersByNumber fetches the headers of the given blocks in batched requests.
+// Headers and errors are returned in the order of numbers.
+func (c *Client) HeadersByNumber(ctx context.Context, numbers []*big.Int) ([]*types.Header, []error) {
+	headers := make([]*types.Header, len(numbers))
+	elems := make([]rpc.BatchElem, len(numbers))
+	for i, number := range numbers {
+		elems[i] = rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{toBlockNumArg(number), false},
+			Result: &headers[i],
+		}
+	}
+	c.batchCall(ctx, false, elems)
+
+	errs := make([]error, len(numbers))
+	for i := range elems {
+		errs[i] = elems[i].Error
+		if errs[i] == nil && headers[i] == nil {
+			errs[i] = celo.NotFound
+		}
+	}
+	return headers, errs
+}
+
+// TransactionReceipts fetches the receipts of the given transactions in
+// batched requests. Receipts and errors are returned in the order of hashes.
+func (c *Client) TransactionReceipts(ctx context.Context, hashes []common.Hash) ([]*types.Receipt, []error) {
+	raws, errs := c.rawReceipts(ctx, hashes)
+	receipts := make([]*types.Receipt, len(hashes))
+	for i := range raws {
+		if errs[i] == nil {
+			receipts[i] = new(types.Receipt)
+			errs[i] = json.Unmarshal(raws[i], receipts[i])
+		}
+	}
+	return receipts, errs
+}
+
+// rawReceipts fetches the undecoded receipts of the given transactions in
+// batched requests. Receipts the node does not know are celo.NotFound.
+func (c *Client) rawReceipts(ctx context.Context, hashes []common.Hash) ([]json.RawMessage, []error) {
+	raws := make([]json.RawMessage, len(hashes))
+	elems := make([]rpc.BatchElem, len(hashes))
+	for i, hash := range hashes {
+		elems[i] = rpc.BatchElem{
+			Method: "eth_getTransactionReceipt",
+			Args:   []interface{}{hash},
+			Result: &raws[i],
+		}
+	}
+	c.batchCall(ctx, false, elems)
+
+	errs := make([]error, len(hashes))
+	for i := range elems {
+		errs[i] = elems[i].Error
+		if errs[i] == nil && (len(raws[i]) == 0 || string(raws[i]) == "null") {
+			errs[i] = celo.NotFound
+		}
+	}
+	return raws, errs
+}
+
+// TxReceipt holds the fields of a transaction receipt the tx indexer reads,
+// including those that types.Receipt drops.
+type TxReceipt struct {
+	TxHash  common.Hash    `json:"transactionHash"`
+	From    common.Address `json:"from"`
+	GasUsed hexutil.Uint64 `json:"gasUsed"`
+	// EffectiveGasPrice is nil when the node could not compute it, e.g.
+	// because the state of the block is pruned.
+	EffectiveGasPrice *hexutil.Big `json:"effectiveGasPrice"`
+	Logs              []*types.Log `json:"logs"`
+}
+
+// TxReceipts fetches the receipts of the given transactions like
+// TransactionReceipts, keeping the fields that types.Receipt drops.
+func (c *Client) TxReceipts(ctx context.Context, hashes []common.Hash) ([]*TxReceipt, []error) {
+	raws, errs := c.rawReceipts(ctx, hashes)
+	receipts := make([]*TxReceipt, len(hashes))
+	for i := range raws {
+		if errs[i] == nil {
+			receipts[i] = new(TxReceipt)
+			errs[i] = json.Unmarshal(raws[i], receipts[i])
+		}
+	}
+	return receipts, errs
+}
+
+// TraceTxs traces the given transactions with the callTracer in batched
+// requests. Traces and errors are returned in the order of txHashes.
+func (c *Client) TraceTxs(ctx context.Context, txHashes []string) ([]map[string]interface{}, []error) {
+	tracerStr := "callTracer"
+	results := make([]map[string]interface{}, len(txHashes))
+	elems := make([]rpc.BatchElem, len(txHashes))
+	for i, txHash := range txHashes {
+		results[i] = make(map[string]interface{}, 0)
+		elems[i] = rpc.BatchElem{
+			Method: "debug_traceTransaction",
+			Args:   []interface{}{txHash, tracers.TraceConfig{Tracer: &tracerStr}},
+			Result: &results[i],
+		}
+	}
+	c.batchCall(ctx, true, elems)
+
+	errs := make([]error, len(txHashes))
+	for i := range elems {
+		errs[i] = elems[i].Error
+	}
+	return results, errs
+}
+
+// BalancesAt fetches the native balances of accounts at the given block in
+// batched requests. Balances and errors are returned in the order of
+// accounts.
+func (c *Client) BalancesAt(ctx context.Context, accounts []common.Address, blockNumber *big.Int) ([]*big.Int, []error) {
+	results := make([]hexutil.Big, len(accounts))
+	elems := make([]rpc.BatchElem, len(accounts))
+	for i, account := range accounts {
+		elems[i] = rpc.BatchElem{
+			Method: "eth_getBalance",
+			Args:   []interface{}{account, toBlockNumArg(blockNumber)},
+			Result: &results[i],
+		}
+	}
+	c.batchCall(ctx, blockNumber != nil, elems)
+
+	balances := make([]*big.Int, len(accounts))
+	errs := make([]error, len(accounts))
+	for i := range elems {
+		balances[i], errs[i] = (*big.Int)(&results[i]), elems[i].Error
+	}
+	return balances, errs
+}
+
+// balanceOfSelector is the selector of the ERC20 balanceOf(address).
+var balanceOfSelector = crypto.Keccak256([]byte("balanceOf(address)"))[:4]
+
+// TokenBalancesAt fetches the ERC20 balances of accounts in token at the
+// given block in batched requests, zero while token has no code yet.
+// Balances and errors are returned in the order of accounts.
+func (c *Client) TokenBalancesAt(ctx context.Context, token common.Address, accounts []common.Address, blockNumber *big.Int) ([]*big.Int, []error) {
+	results := make([]hexutil.Bytes, len(accounts))
+	elems := make([]rpc.BatchElem, len(accounts))
+	for i, account := range accounts {
+		input := append(append([]byte{}, balanceOfSelector...), common.LeftPadBytes(account.Bytes(), 32)...)
+		elems[i] = rpc.BatchElem{
+			Method: "eth_call",
+			Args:   []interface{}{map[string]interface{}{"to": token, "data": hexutil.Bytes(input)}, toBlockNumArg(blockNumber)},
+			Result: &results[i],
+		}
+	}
+	c.batchCall(ctx, blockNumber != nil, elems)
+
+	balances := make([]*big.Int, len(accounts))
+	errs := make([]error, len(accounts))
+	for i := range elems {
+		if errs[i] = elems[i].Error; errs[i] != nil {
+			continue
+		}
+		switch len(results[i]) {
+		case 0:
+			balances[i] = big.NewInt(0)
+		case 32:
+			balances[i] = new(big.Int).SetBytes(results[i])
+		default:
+			errs[i] = fmt.Errorf("balanceOf of %v returned %d bytes", token, len(results[i]))
+		}
+	}
+	return balances, errs
+}
+
+// LockedGoldAt fetches the positions of accounts in the LockedGold contract
+// at lockedGold at the given block in batched requests: the CELO they have
+// locked and the sum of their pending withdrawals. Positions and errors are
+// returned in the order of accounts.
+func (c *Client) LockedGoldAt(ctx context.Context, lockedGold common.Address, accounts []common.Address, blockNumber *big.Int) ([]*big.Int, []*big.Int, []error) {
+	results, errs := c.callLockedGold(ctx, lockedGold, accounts, blockNumber, "getAccountTotalLockedGold", "getPendingWithdrawals")
+	locked := make([]*big.Int, len(accounts))
+	pending := make([]*big.Int, len(accounts))
+	for i := range accounts {
+		if errs[i] != nil {
+			continue
+		}
+		var total *big.Int
+		if errs[i] = lockedGoldABI.UnpackIntoInterface(&total, "getAccountTotalLockedGold", results[i][0]); errs[i] != nil {
+			continue
+		}
+		withdrawals, err := lockedGoldABI.Unpack("getPendingWithdrawals", results[i][1])
+		if errs[i] = err; err != nil {
+			continue
+		}
+		sum := big.NewInt(0)
+		for _, value := range withdrawals[0].([]*big.Int) {
+			sum.Add(sum, value)
+		}
+		locked[i], pending[i] = total, sum
+	}
+	return locked, pending, errs
+}
+
+// TotalLockedGoldAt fetches the CELO accounts have locked in the LockedGold
+// contract at lockedGold at the given block in batched requests, zero while
+// the contract has no code yet. Amounts and errors are returned in the order
+// of accounts.
+func (c *Client) TotalLockedGoldAt(ctx context.Context, lockedGold common.Address, accounts []common.Address, blockNumber *big.Int) ([]*big.Int, []error) {
+	results, errs := c.callLockedGold(ctx, lockedGold, accounts, blockNumber, "getAccountTotalLockedGold")
+	totals := make([]*big.Int, len(accounts))
+	for i := range accounts {
+		if errs[i] != nil {
+			continue
+		}
+		if len(results[i][0]) == 0 {
+			totals[i] = big.NewInt(0)
+			continue
+		}
+		errs[i] = lockedGoldABI.UnpackIntoInterface(&totals[i], "getAccountTotalLockedGold", results[i][0])
+	}
+	return totals, errs
+}
+
+// callLockedGold calls methods of the LockedGold contract at lockedGold with
+// each of accounts at the given block in batched requests. The results of an
+// account are in the order of methods, its error is the first of its calls.
+func (c *Client) callLockedGold(ctx context.Context, lockedGold common.Address, accounts []common.Address, blockNumber *big.Int, methods ...string) ([][]hexutil.Bytes, []error) {
+	results := make([][]hexutil.Bytes, len(accounts))
+	elems := make([]rpc.BatchElem, 0, len(methods)*len(accounts))
+	for i, account := range accounts {
+		results[i] = make([]hexutil.Bytes, len(methods))
+		for j, method := range methods {
+			input, err := lockedGoldABI.Pack(method, account)
+			if err != nil {
+				panic(err)
+			}
+			elems = append(elems, rpc.BatchElem{
+				Method: "eth_call",
+				Args:   []interface{}{map[string]interface{}{"to": lockedGold, "data": hexutil.Bytes(input)}, toBlockNumArg(blockNumber)},
+				Result: &results[i][j],
+			})
+		}
+	}
+	c.batchCall(ctx, blockNumber != nil, elems)
+
+	errs := make([]error, len(accounts))
+	for i := range accounts {
+		for _, elem := range elems[i*len(methods) : (i+1)*len(methods)] {
+			if elem.Error != nil {
+				errs[i] = elem.Error
+				break
+			}
+		}
+	}
+	return results, errs
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
+	"time"
 
 	"github.com/celo-org/celo-blockchain"
 	"github.com/celo-org/celo-blockchain/common"
@@ -14,25 +16,142 @@ import (
 	"github.com/celo-org/celo-blockchain/crypto"
 	"github.com/celo-org/celo-blockchain/eth/tracers"
 	"github.com/celo-org/celo-blockchain/rpc"
+	"github.com/xuxinlai2002/creda-celo-balance/config"
 )
 
+// defaultPoolOptions are used by Dial and for unset config values.
+var defaultPoolOptions = poolOptions{
+	healthCheckInterval: 30 * time.Second,
+	maxRetries:          5,
+	retryBaseDelay:      500 * time.Millisecond,
+	retryMaxDelay:       30 * time.Second,
+}
+
 type Client struct {
-	rpcClient *rpc.Client
+	pool *pool
+
+	// fixture is the recorder or replayer of the fixture transport modes.
+	fixture io.Closer
 }
 
+// Dial connects to a single endpoint that serves every method, archive ones
+// included.
 func Dial(rawurl string) (*Client, error) {
-	rpcClient, err := rpc.DialContext(context.TODO(), rawurl)
+	opts := defaultPoolOptions
+	opts.healthCheckInterval = 0
+	return dial([]Endpoint{{URL: rawurl, Weight: 1, Archive: true}}, opts, "", "")
+}
+
+// DialRecord is like Dial but appends every JSON-RPC call and its answer to
+// the fixture file at path, to be served later by DialReplay.
+func DialRecord(rawurl, path string) (*Client, error) {
+	opts := defaultPoolOptions
+	opts.healthCheckInterval = 0
+	return dial([]Endpoint{{URL: rawurl, Weight: 1, Archive: true}}, opts, path, "")
+}
+
+// DialReplay returns a client answering calls from the fixture file at path
+// without touching the network. Calls missing from the fixture fail.
+func DialReplay(path string) (*Client, error) {
+	opts := defaultPoolOptions
+	opts.healthCheckInterval = 0
+	opts.maxRetries = -1
+	return dial(nil, opts, "", path)
+}
+
+// dial creates the client of endpoints. A non-empty record path records all
+// calls, a non-empty replay path replaces the endpoints by the fixture.
+func dial(endpoints []Endpoint, opts poolOptions, record, replay string) (*Client, error) {
+	var fixture io.Closer
+	switch {
+	case replay != "":
+		r, err := newReplayer(replay)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = []Endpoint{{URL: replayURL, Weight: 1, Archive: true}}
+		opts.healthCheckInterval = 0
+		opts.transport, fixture = r, r
+	case record != "":
+		r, err := newRecorder(record)
+		if err != nil {
+			return nil, err
+		}
+		opts.transport, fixture = r, r
+	}
+
+	p, err := newPool(context.TODO(), endpoints, opts)
 	if err != nil {
+		if fixture != nil {
+			fixture.Close()
+		}
 		return nil, err
 	}
 	return &Client{
-		rpcClient: rpcClient,
+		pool:    p,
+		fixture: fixture,
 	}, nil
 }
 
+// DialConfig connects to the endpoints configured in cfg. When no endpoint
+// list is configured the single cfg.HTTP url is used as an archive endpoint.
+// cfg.RecordFixture and cfg.ReplayFixture select the fixture transport modes
+// of DialRecord and DialReplay.
+func DialConfig(cfg *config.Config) (*Client, error) {
+	endpoints := make([]Endpoint, len(cfg.Endpoints))
+	for i, e := range cfg.Endpoints {
+		endpoints[i] = Endpoint{URL: e.URL, Weight: e.Weight, Archive: e.Archive}
+	}
+	opts := defaultPoolOptions
+	opts.maxLag = cfg.MaxBlockLag
+	if cfg.HealthCheckInterval > 0 {
+		opts.healthCheckInterval = time.Duration(cfg.HealthCheckInterval) * time.Second
+	}
+	if len(endpoints) == 0 {
+		endpoints = []Endpoint{{URL: cfg.HTTP, Weight: 1, Archive: true}}
+		opts.healthCheckInterval = 0
+	}
+	if cfg.MaxRetries != 0 {
+		opts.maxRetries = cfg.MaxRetries
+	}
+	if cfg.RetryBaseDelay > 0 {
+		opts.retryBaseDelay = time.Duration(cfg.RetryBaseDelay) * time.Millisecond
+	}
+	if cfg.RetryMaxDelay > 0 {
+		opts.retryMaxDelay = time.Duration(cfg.RetryMaxDelay) * time.Millisecond
+	}
+	opts.requestsPerSecond = cfg.RequestsPerSecond
+	opts.requestBurst = cfg.RequestBurst
+
+	return dial(endpoints, opts, cfg.RecordFixture, cfg.ReplayFixture)
+}
+
+// Close stops the health checks and closes all endpoint connections and the
+// fixture file.
+func (c *Client) Close() {
+	c.pool.close()
+	if c.fixture != nil {
+		c.fixture.Close()
+	}
+}
+
+func (c *Client) call(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	return c.pool.call(ctx, false, 1, func(rpcClient *rpc.Client) error {
+		return rpcClient.CallContext(ctx, result, method, args...)
+	})
+}
+
+// callArchive is like call but only uses archive endpoints. It must be used
+// for traces and for state queries at historical blocks.
+func (c *Client) callArchive(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	return c.pool.call(ctx, true, 1, func(rpcClient *rpc.Client) error {
+		return rpcClient.CallContext(ctx, result, method, args...)
+	})
+}
+
 func (c *Client) ChainID(ctx context.Context) (*big.Int, error) {
 	var result hexutil.Big
-	err := c.rpcClient.CallContext(ctx, &result, "eth_chainId")
+	err := c.call(ctx, &result, "eth_chainId")
 	if err != nil {
 		return nil, err
 	}
@@ -62,20 +181,52 @@ func (c *Client) BlockByNumber(ctx context.Context, number *big.Int) (*types.Blo
 	return c.getBlock(ctx, "eth_getBlockByNumber", toBlockNumArg(number), true)
 }
 
+// HeaderByNumber returns the header of the given block without fetching its
+// transactions.
+func (c *Client) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	var head *types.Header
+	err := c.call(ctx, &head, "eth_getBlockByNumber", toBlockNumArg(number), false)
+	if err == nil && head == nil {
+		err = celo.NotFound
+	}
+	return head, err
+}
+
 func (c *Client) TraceTx(ctx context.Context, txHash string) (map[string]interface{}, error) {
 	var result map[string]interface{} = make(map[string]interface{}, 0)
 	tracerStr := "callTracer"
-	err := c.rpcClient.CallContext(ctx, &result, "debug_traceTransaction", txHash, tracers.TraceConfig{Tracer: &tracerStr})
+	err := c.callArchive(ctx, &result, "debug_traceTransaction", txHash, tracers.TraceConfig{Tracer: &tracerStr})
 	if err != nil {
 		return result, err
 	}
 	return result, nil
 }
 
+// TxTraceResult is the callTracer output for one transaction of a block trace.
+// TxHash is only filled in by nodes that report it; older nodes return the
+// results in block transaction order without hashes.
+type TxTraceResult struct {
+	TxHash common.Hash            `json:"txHash"`
+	Result map[string]interface{} `json:"result"`
+	Error  string                 `json:"error"`
+}
+
+// TraceBlock traces every transaction of the given block with the callTracer
+// in a single debug_traceBlockByNumber call.
+func (c *Client) TraceBlock(ctx context.Context, number *big.Int) ([]*TxTraceResult, error) {
+	var result []*TxTraceResult
+	tracerStr := "callTracer"
+	err := c.callArchive(ctx, &result, "debug_traceBlockByNumber", toBlockNumArg(number), tracers.TraceConfig{Tracer: &tracerStr})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 func (c *Client) LatestBlock() (*big.Int, error) {
 	var head *headerNumber
 
-	err := c.rpcClient.CallContext(context.Background(), &head, "eth_getBlockByNumber", "latest", false)
+	err := c.call(context.Background(), &head, "eth_getBlockByNumber", "latest", false)
 	if err == nil && head == nil {
 		err = errors.New("not found")
 		return nil, err
@@ -90,7 +241,7 @@ func (c *Client) LatestBlock() (*big.Int, error) {
 // Note that the receipt is not available for pending transactions.
 func (c *Client) TransactionReceipt(txHash common.Hash) (*types.Receipt, error) {
 	var r *types.Receipt
-	err := c.rpcClient.CallContext(context.Background(), &r, "eth_getTransactionReceipt", txHash)
+	err := c.call(context.Background(), &r, "eth_getTransactionReceipt", txHash)
 	if err == nil {
 		if r == nil {
 			return nil, celo.NotFound
@@ -99,15 +250,31 @@ func (c *Client) TransactionReceipt(txHash common.Hash) (*types.Receipt, error)
 	return r, err
 }
 
+// BlockReceipt returns the receipt of the system calls of the block with the
+// given hash. Its logs are those emitted outside of transactions, e.g. when
+// paying the epoch rewards.
+func (c *Client) BlockReceipt(ctx context.Context, blockHash common.Hash) (*types.Receipt, error) {
+	var r *types.Receipt
+	err := c.call(ctx, &r, "eth_getBlockReceipt", blockHash)
+	if err == nil && r == nil {
+		return nil, celo.NotFound
+	}
+	return r, err
+}
+
 func (c *Client) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
 	var result hexutil.Big
-	err := c.rpcClient.CallContext(ctx, &result, "eth_getBalance", account, toBlockNumArg(blockNumber))
+	call := c.call
+	if blockNumber != nil {
+		call = c.callArchive
+	}
+	err := call(ctx, &result, "eth_getBalance", account, toBlockNumArg(blockNumber))
 	return (*big.Int)(&result), err
 }
 
 func (c *Client) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
 	var hex hexutil.Big
-	if err := c.rpcClient.CallContext(ctx, &hex, "eth_gasPrice"); err != nil {
+	if err := c.call(ctx, &hex, "eth_gasPrice"); err != nil {
 		return nil, err
 	}
 	return (*big.Int)(&hex), nil
@@ -115,7 +282,11 @@ func (c *Client) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
 
 func (c *Client) CallContract(ctx context.Context, callArgs map[string]interface{}, blockNumber *big.Int) ([]byte, error) {
 	var hex hexutil.Bytes
-	err := c.rpcClient.CallContext(ctx, &hex, "eth_call", callArgs, toBlockNumArg(blockNumber))
+	call := c.call
+	if blockNumber != nil {
+		call = c.callArchive
+	}
+	err := call(ctx, &hex, "eth_call", callArgs, toBlockNumArg(blockNumber))
 	if err != nil {
 		return nil, err
 	}
@@ -123,10 +294,17 @@ func (c *Client) CallContract(ctx context.Context, callArgs map[string]interface
 }
 
 func (c *Client) BuildQuery(contractAddress string, sig []byte, startBlock *big.Int, endBlock *big.Int) celo.FilterQuery {
+	return c.BuildMultiQuery([]common.Address{common.HexToAddress(contractAddress)}, sig, startBlock, endBlock)
+}
+
+// BuildMultiQuery is like BuildQuery but matches the events of any of the
+// given contracts. At least one contract is required, a query without
+// addresses would match every contract.
+func (c *Client) BuildMultiQuery(contractAddresses []common.Address, sig []byte, startBlock *big.Int, endBlock *big.Int) celo.FilterQuery {
 	query := celo.FilterQuery{
 		FromBlock: startBlock,
 		ToBlock:   endBlock,
-		Addresses: []common.Address{common.HexToAddress(contractAddress)},
+		Addresses: contractAddresses,
 		Topics: [][]common.Hash{
 			{crypto.Keccak256Hash(sig)},
 		},
@@ -134,15 +312,54 @@ func (c *Client) BuildQuery(contractAddress string, sig []byte, startBlock *big.
 	return query
 }
 
-// FilterLogs executes a filter query.
+// FilterLogs executes a filter query. Block ranges the node rejects as too
+// large are split in halves until every piece succeeds.
 func (c *Client) FilterLogs(ctx context.Context, q celo.FilterQuery) ([]types.Log, error) {
+	logs, _, err := c.FilterLogsSplit(ctx, q)
+	return logs, err
+}
+
+// FilterLogsSplit is like FilterLogs and also returns the largest number of
+// blocks a single request succeeded with. It is smaller than the query range
+// when the range had to be split, which lets callers adapt their range size.
+func (c *Client) FilterLogsSplit(ctx context.Context, q celo.FilterQuery) ([]types.Log, uint64, error) {
 	var result []types.Log
 	arg, err := toFilterArg(q)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	err = c.rpcClient.CallContext(ctx, &result, "eth_getLogs", arg)
-	return result, err
+	err = c.call(ctx, &result, "eth_getLogs", arg)
+	if err == nil || !isTooManyLogs(err) || q.FromBlock == nil || q.ToBlock == nil {
+		span := uint64(0)
+		if err == nil && q.FromBlock != nil && q.ToBlock != nil {
+			span = new(big.Int).Sub(q.ToBlock, q.FromBlock).Uint64() + 1
+		}
+		return result, span, err
+	}
+
+	if q.FromBlock.Cmp(q.ToBlock) >= 0 {
+		return nil, 0, fmt.Errorf("block %v alone exceeds the log limit: %v", q.FromBlock, err)
+	}
+	mid := new(big.Int).Add(q.FromBlock, q.ToBlock)
+	mid.Rsh(mid, 1)
+	log.Debugf("splitting logs query %v-%v: %v", q.FromBlock, q.ToBlock, err)
+
+	left, right := q, q
+	left.ToBlock = mid
+	right.FromBlock = new(big.Int).Add(mid, big.NewInt(1))
+	leftLogs, leftSpan, err := c.FilterLogsSplit(ctx, left)
+	if err != nil {
+		return nil, 0, err
+	}
+	rightLogs, rightSpan, err := c.FilterLogsSplit(ctx, right)
+	if err != nil {
+		return nil, 0, err
+	}
+	span := leftSpan
+	if rightSpan > span {
+		span = rightSpan
+	}
+	return append(leftLogs, rightLogs...), span, nil
 }
 
 func toFilterArg(q celo.FilterQuery) (interface{}, error) {
@@ -200,10 +417,15 @@ func (tx *rpcTransaction) UnmarshalJSON(msg []byte) error {
 
 func (c *Client) getBlock(ctx context.Context, method string, args ...interface{}) (*types.Block, error) {
 	var raw json.RawMessage
-	err := c.rpcClient.CallContext(ctx, &raw, method, args...)
+	err := c.call(ctx, &raw, method, args...)
 	if err != nil {
 		return nil, err
-	} else if len(raw) == 0 {
+	}
+	return decodeBlock(raw)
+}
+
+func decodeBlock(raw json.RawMessage) (*types.Block, error) {
+	if len(raw) == 0 || string(raw) == "null" {
 		return nil, errors.New("not found")
 	}
 	// Decode header and transactions.
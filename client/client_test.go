@@ -0,0 +1,292 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain"
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/client/mocknode"
+	"github.com/xuxinlai2002/creda-celo-balance/config"
+)
+
+var (
+	alice = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	bob   = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+	cUSD  = common.HexToAddress("0x765DE816845861e75A25fCA122bb6898B8B1282a")
+)
+
+// testConfig returns a config that dials url and retries quickly.
+func testConfig(url string) *config.Config {
+	return &config.Config{
+		HTTP:           url,
+		MaxRetries:     3,
+		RetryBaseDelay: 1,
+		RetryMaxDelay:  10,
+	}
+}
+
+func dial(t *testing.T, node *mocknode.Node) *client.Client {
+	cli, err := client.DialConfig(testConfig(node.URL))
+	require.NoError(t, err)
+	t.Cleanup(cli.Close)
+	return cli
+}
+
+// TestBlocksByNumber tests that batched block fetches decode Celo blocks and
+// keep the input order.
+func TestBlocksByNumber(t *testing.T) {
+	node := mocknode.New(1000)
+	defer node.Close()
+	node.AddBlock(1005, &mocknode.Tx{From: alice, To: &bob, Value: big.NewInt(7)})
+	node.AddBlock(1010)
+	cli := dial(t, node)
+
+	numbers := []*big.Int{big.NewInt(2), big.NewInt(1), big.NewInt(9)}
+	blocks, errs := cli.BlocksByNumber(context.Background(), numbers)
+	require.Len(t, blocks, 3)
+
+	require.NoError(t, errs[0])
+	require.Equal(t, node.Header(2).Hash(), blocks[0].Hash())
+	require.Empty(t, blocks[0].Transactions())
+
+	require.NoError(t, errs[1])
+	require.Equal(t, node.Header(1).Hash(), blocks[1].Hash())
+	require.Len(t, blocks[1].Transactions(), 1)
+	require.Equal(t, big.NewInt(7), blocks[1].Transactions()[0].Value())
+	require.NotNil(t, blocks[1].Randomness())
+
+	require.Error(t, errs[2])
+	require.Equal(t, 3, node.Calls("eth_getBlockByNumber"))
+}
+
+// TestHeaderByNumber tests that headers are fetched without transactions.
+func TestHeaderByNumber(t *testing.T) {
+	node := mocknode.New(1000)
+	defer node.Close()
+	node.AddBlock(1005, &mocknode.Tx{From: alice, To: &bob, Value: big.NewInt(7)})
+	cli := dial(t, node)
+
+	header, err := cli.HeaderByNumber(context.Background(), big.NewInt(1))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1005), header.Time)
+	require.Equal(t, node.Header(1).Hash(), header.Hash())
+}
+
+// TestReceipts tests that receipts decode both as types.Receipt and with the
+// fields it drops, and that unknown transactions are not found.
+func TestReceipts(t *testing.T) {
+	node := mocknode.New(1000)
+	defer node.Close()
+	node.AddBlock(1005, &mocknode.Tx{From: alice, To: &bob, Value: big.NewInt(7), GasUsed: 21000, EffectiveGasPrice: big.NewInt(5)})
+	cli := dial(t, node)
+	block, err := cli.BlockByNumber(context.Background(), big.NewInt(1))
+	require.NoError(t, err)
+	hashes := []common.Hash{block.Transactions()[0].Hash(), common.HexToHash("0x01")}
+
+	receipts, errs := cli.TransactionReceipts(context.Background(), hashes)
+	require.NoError(t, errs[0])
+	require.Equal(t, hashes[0], receipts[0].TxHash)
+	require.Equal(t, uint64(21000), receipts[0].GasUsed)
+	require.ErrorIs(t, errs[1], celo.NotFound)
+
+	txReceipts, errs := cli.TxReceipts(context.Background(), hashes)
+	require.NoError(t, errs[0])
+	require.Equal(t, alice, txReceipts[0].From)
+	require.Equal(t, int64(5), txReceipts[0].EffectiveGasPrice.ToInt().Int64())
+	require.ErrorIs(t, errs[1], celo.NotFound)
+}
+
+// TestFilterLogsSplit tests that log queries rejected for matching too many
+// logs are bisected until every piece succeeds.
+func TestFilterLogsSplit(t *testing.T) {
+	node := mocknode.New(1000)
+	defer node.Close()
+	for i := 0; i < 8; i++ {
+		node.AddBlock(uint64(1000+i), &mocknode.Tx{
+			From: alice,
+			To:   &cUSD,
+			Logs: []*mocknode.Log{mocknode.TransferLog(cUSD, alice, bob, big.NewInt(int64(i+1)))},
+		})
+	}
+	node.MaxLogs = 3
+	cli := dial(t, node)
+
+	query := cli.BuildQuery(cUSD.Hex(), []byte("Transfer(address,address,uint256)"), big.NewInt(1), big.NewInt(8))
+	logs, span, err := cli.FilterLogsSplit(context.Background(), query)
+	require.NoError(t, err)
+	require.Len(t, logs, 8)
+	require.Equal(t, uint64(2), span)
+	for i, l := range logs {
+		require.Equal(t, uint64(i+1), l.BlockNumber)
+	}
+}
+
+// TestFilterLogsInvalidRange tests that log queries rejected for an invalid
+// range fail at once instead of being bisected.
+func TestFilterLogsInvalidRange(t *testing.T) {
+	node := mocknode.New(1000)
+	defer node.Close()
+	cli := dial(t, node)
+
+	query := cli.BuildQuery(cUSD.Hex(), []byte("Transfer(address,address,uint256)"), big.NewInt(1), big.NewInt(8))
+	node.FailRPC("eth_getLogs", -32000, "invalid block range params", 1)
+	_, _, err := cli.FilterLogsSplit(context.Background(), query)
+	require.ErrorContains(t, err, "invalid block range")
+	require.Equal(t, 1, node.Calls("eth_getLogs"))
+
+	node.FailRPC("eth_getLogs", -32005, "requested range exceeds the limit of 4 blocks", 1)
+	_, span, err := cli.FilterLogsSplit(context.Background(), query)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), span)
+}
+
+// TestTraceBlock tests that block traces map back to their transactions.
+func TestTraceBlock(t *testing.T) {
+	node := mocknode.New(1000)
+	defer node.Close()
+	tx := &mocknode.Tx{From: alice, To: &bob, Value: big.NewInt(7)}
+	node.AddBlock(1005, tx)
+	cli := dial(t, node)
+
+	results, err := cli.TraceBlock(context.Background(), big.NewInt(1))
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, tx.Hash(), results[0].TxHash)
+	require.Equal(t, "0x7", results[0].Result["value"])
+}
+
+// TestRetry tests that rate limits and server errors are retried.
+func TestRetry(t *testing.T) {
+	node := mocknode.New(1000)
+	defer node.Close()
+	cli := dial(t, node)
+
+	node.FailHTTP("eth_chainId", http.StatusTooManyRequests, 1)
+	node.FailHTTP("eth_chainId", http.StatusBadGateway, 1)
+	node.FailRPC("eth_chainId", -32005, "daily request limit exceeded", 1)
+	chainID, err := cli.ChainID(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(42220), chainID)
+
+	node.FailRPC("eth_chainId", -32000, "execution reverted", 1)
+	_, err = cli.ChainID(context.Background())
+	require.Error(t, err)
+}
+
+// TestPoolFailover tests that calls fail over to healthy endpoints and that
+// archive calls only use archive endpoints.
+func TestPoolFailover(t *testing.T) {
+	down := mocknode.New(1000)
+	down.Close()
+	full := mocknode.New(1000)
+	defer full.Close()
+	archive := mocknode.New(1000)
+	defer archive.Close()
+	tx := &mocknode.Tx{From: alice, To: &bob, Value: big.NewInt(7)}
+	for _, node := range []*mocknode.Node{full, archive} {
+		node.AddBlock(1005, tx)
+	}
+
+	cfg := testConfig("")
+	cfg.Endpoints = []config.EndpointConfig{
+		{URL: down.URL, Weight: 10},
+		{URL: full.URL},
+		{URL: archive.URL, Archive: true},
+	}
+	cli, err := client.DialConfig(cfg)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := cli.ChainID(context.Background())
+		require.NoError(t, err)
+	}
+
+	traces, errs := cli.TraceTxs(context.Background(), []string{tx.Hash().Hex()})
+	require.NoError(t, errs[0])
+	require.Equal(t, "0x7", traces[0]["value"])
+	require.Equal(t, 0, full.Calls("debug_traceTransaction"))
+	require.Equal(t, 1, archive.Calls("debug_traceTransaction"))
+}
+
+// TestDecodeError tests that an answer failing to decode is returned at once
+// instead of being retried or failed over.
+func TestDecodeError(t *testing.T) {
+	nodes := []*mocknode.Node{mocknode.New(1000), mocknode.New(1000)}
+	cfg := testConfig("")
+	for _, node := range nodes {
+		defer node.Close()
+		node.CorruptResult("eth_getBlockByNumber", json.RawMessage(`{"number":"0x0"}`), 1)
+		cfg.Endpoints = append(cfg.Endpoints, config.EndpointConfig{URL: node.URL})
+	}
+	cli, err := client.DialConfig(cfg)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.HeaderByNumber(context.Background(), big.NewInt(0))
+	require.ErrorContains(t, err, "missing required field")
+	require.Equal(t, 1, nodes[0].Calls("eth_getBlockByNumber")+nodes[1].Calls("eth_getBlockByNumber"))
+}
+
+// TestRecordReplay tests that calls recorded against a node are answered
+// from the fixture once the node is gone, batches and node errors included.
+func TestRecordReplay(t *testing.T) {
+	node := mocknode.New(1000)
+	tx := &mocknode.Tx{Type: types.CeloDynamicFeeTxType, From: alice, To: &bob, Value: big.NewInt(7), FeeCurrency: &cUSD}
+	node.AddBlock(1005, tx)
+	fixture := filepath.Join(t.TempDir(), "fixture.jsonl")
+
+	ctx := context.Background()
+	cli, err := client.DialRecord(node.URL, fixture)
+	require.NoError(t, err)
+	recorded, err := cli.BlockByNumber(ctx, big.NewInt(1))
+	require.NoError(t, err)
+	_, errs := cli.HeadersByNumber(ctx, []*big.Int{big.NewInt(0), big.NewInt(1)})
+	require.NoError(t, errs[0])
+	_, err = cli.TraceBlock(ctx, big.NewInt(1))
+	require.NoError(t, err)
+	node.FailRPC("eth_chainId", -32000, "execution reverted", 1)
+	_, err = cli.ChainID(ctx)
+	require.Error(t, err)
+	_, err = cli.ChainID(ctx)
+	require.NoError(t, err)
+	cli.Close()
+	node.Close()
+
+	cli, err = client.DialReplay(fixture)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	b, err := cli.BlockByNumber(ctx, big.NewInt(1))
+	require.NoError(t, err)
+	require.Equal(t, recorded.Hash(), b.Hash())
+	require.Equal(t, tx.Hash(), b.Transactions()[0].Hash())
+	require.Equal(t, uint8(types.CeloDynamicFeeTxType), b.Transactions()[0].Type())
+	require.Equal(t, &cUSD, b.Transactions()[0].FeeCurrency())
+
+	headers, errs := cli.HeadersByNumber(ctx, []*big.Int{big.NewInt(0), big.NewInt(1)})
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+	require.Equal(t, recorded.Hash(), headers[1].Hash())
+
+	traces, err := cli.TraceBlock(ctx, big.NewInt(1))
+	require.NoError(t, err)
+	require.Equal(t, tx.Hash(), traces[0].TxHash)
+
+	_, err = cli.ChainID(ctx)
+	require.EqualError(t, err, "execution reverted")
+	chainID, err := cli.ChainID(ctx)
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(42220), chainID)
+
+	_, err = cli.BlockByNumber(ctx, big.NewInt(2))
+	require.ErrorContains(t, err, "no fixture for eth_getBlockByNumber")
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/celo-org/celo-blockchain/accounts/abi"
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/common/hexutil"
+	"github.com/celo-org/celo-blockchain/contracts/abis"
+	"github.com/celo-org/celo-blockchain/contracts/config"
+)
+
+// errNoCode is returned by calls of an account without code.
+var errNoCode = errors.New("no contract code")
+
+// RegisteredAddress returns the address registered under id in the Celo
+// registry at the given block, the zero address if there is none or the
+// registry is not deployed yet.
+func (c *Client) RegisteredAddress(ctx context.Context, id common.Hash, blockNumber *big.Int) (common.Address, error) {
+	var address common.Address
+	err := c.callABI(ctx, &address, config.RegistrySmartContractAddress, abis.Registry, "getAddressFor", blockNumber, id)
+	if errors.Is(err, errNoCode) {
+		return common.Address{}, nil
+	}
+	return address, err
+}
+
+// GasPriceMinimum returns the gas price minimum in currency at the given
+// block, zero if the GasPriceMinimum contract is not registered yet.
+func (c *Client) GasPriceMinimum(ctx context.Context, currency common.Address, blockNumber *big.Int) (*big.Int, error) {
+	address, err := c.RegisteredAddress(ctx, config.GasPriceMinimumRegistryId, blockNumber)
+	if err != nil || address == (common.Address{}) {
+		return big.NewInt(0), err
+	}
+	var gpm *big.Int
+	err = c.callABI(ctx, &gpm, address, abis.GasPriceMinimum, "getGasPriceMinimum", blockNumber, currency)
+	return gpm, err
+}
+
+// lockedGoldABI holds the LockedGold views reading the position of an
+// account, which the ABIs of celo-blockchain leave out.
+var lockedGoldABI, _ = abi.JSON(strings.NewReader(`[` +
+	`{"name":"getAccountTotalLockedGold","type":"function","stateMutability":"view",` +
+	`"inputs":[{"name":"account","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},` +
+	`{"name":"getPendingWithdrawals","type":"function","stateMutability":"view",` +
+	`"inputs":[{"name":"account","type":"address"}],"outputs":[{"name":"","type":"uint256[]"},{"name":"","type":"uint256[]"}]}]`))
+
+// callABI calls method of the contract at to with the given ABI and unpacks
+// its single return value into result.
+func (c *Client) callABI(ctx context.Context, result interface{}, to common.Address, contract *abi.ABI, method string, blockNumber *big.Int, args ...interface{}) error {
+	input, err := contract.Pack(method, args...)
+	if err != nil {
+		return err
+	}
+	ret, err := c.CallContract(ctx, map[string]interface{}{
+		"to":   to,
+		"data": hexutil.Bytes(input),
+	}, blockNumber)
+	if err != nil {
+		return err
+	}
+	if len(ret) == 0 {
+		return fmt.Errorf("%s of %v: %w", method, to, errNoCode)
+	}
+	return contract.UnpackIntoInterface(result, method, ret)
+}
@@ -0,0 +1,250 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// replayURL is the endpoint url of replaying clients, it is never dialed.
+const replayURL = "http://replay.invalid"
+
+// fixtureEntry is one recorded JSON-RPC call. Fixture files hold one entry
+// per line.
+type fixtureEntry struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+type jsonrpcMessage struct {
+	Version string          `json:"jsonrpc,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   json.RawMessage `json:"error,omitempty"`
+}
+
+// parseMessages decodes a single JSON-RPC message or a batch of them. The
+// boolean reports whether body is a batch.
+func parseMessages(body []byte) ([]*jsonrpcMessage, bool, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var msgs []*jsonrpcMessage
+		err := json.Unmarshal(body, &msgs)
+		return msgs, true, err
+	}
+	var msg jsonrpcMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, false, err
+	}
+	return []*jsonrpcMessage{&msg}, false, nil
+}
+
+// fixtureKey identifies a call by method and params. Params are re-encoded
+// so that object keys are sorted.
+func fixtureKey(method string, params json.RawMessage) string {
+	var v interface{}
+	if len(params) > 0 && json.Unmarshal(params, &v) == nil {
+		params, _ = json.Marshal(v)
+	}
+	return method + string(params)
+}
+
+// recorder is an http.RoundTripper that appends every successful JSON-RPC
+// call passing through it to a fixture file.
+type recorder struct {
+	base http.RoundTripper
+
+	lock sync.Mutex
+	file *os.File
+}
+
+func newRecorder(path string) (*recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open fixture %s err: %v", path, err)
+	}
+	return &recorder{base: http.DefaultTransport, file: file}, nil
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+	resp, err := r.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	// Only complete answers of the node are worth replaying, rate limits and
+	// gateway errors are not.
+	if resp.StatusCode == http.StatusOK {
+		if err := r.record(reqBody, respBody); err != nil {
+			log.Warnf("record fixture failed: %v", err)
+		}
+	}
+	return resp, nil
+}
+
+func (r *recorder) record(reqBody, respBody []byte) error {
+	calls, _, err := parseMessages(reqBody)
+	if err != nil {
+		return err
+	}
+	answers, _, err := parseMessages(respBody)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]*jsonrpcMessage, len(answers))
+	for _, a := range answers {
+		byID[string(a.ID)] = a
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, c := range calls {
+		a, ok := byID[string(c.ID)]
+		if !ok {
+			continue
+		}
+		if err := enc.Encode(&fixtureEntry{Method: c.Method, Params: c.Params, Result: a.Result, Error: a.Error}); err != nil {
+			return err
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	_, err = r.file.Write(buf.Bytes())
+	return err
+}
+
+func (r *recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.file.Close()
+}
+
+// replayer is an http.RoundTripper answering JSON-RPC calls from a fixture
+// file. Calls recorded several times are answered in recorded order, the
+// last answer is repeated once they are used up.
+type replayer struct {
+	lock    sync.Mutex
+	answers map[string][]*fixtureEntry
+}
+
+func newReplayer(path string) (*replayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open fixture %s err: %v", path, err)
+	}
+	defer file.Close()
+
+	r := &replayer{answers: make(map[string][]*fixtureEntry)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e fixtureEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("fixture %s line %d err: %v", path, line, err)
+		}
+		key := fixtureKey(e.Method, e.Params)
+		r.answers[key] = append(r.answers[key], &e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read fixture %s err: %v", path, err)
+	}
+	return r, nil
+}
+
+func (r *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil {
+		return nil, errors.New("replay: empty request")
+	}
+	reqBody, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	calls, batch, err := parseMessages(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("replay: %v", err)
+	}
+
+	answers := make([]*jsonrpcMessage, len(calls))
+	for i, c := range calls {
+		answers[i] = r.answer(c)
+	}
+	var respBody []byte
+	if batch {
+		respBody, err = json.Marshal(answers)
+	} else {
+		respBody, err = json.Marshal(answers[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
+
+func (r *replayer) answer(call *jsonrpcMessage) *jsonrpcMessage {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	msg := &jsonrpcMessage{Version: "2.0", ID: call.ID}
+	key := fixtureKey(call.Method, call.Params)
+	entries := r.answers[key]
+	if len(entries) == 0 {
+		msg.Error, _ = json.Marshal(map[string]interface{}{
+			"code":    -32000,
+			"message": fmt.Sprintf("no fixture for %s %s", call.Method, call.Params),
+		})
+		return msg
+	}
+	e := entries[0]
+	if len(entries) > 1 {
+		r.answers[key] = entries[1:]
+	}
+	msg.Result, msg.Error = e.Result, e.Error
+	if msg.Result == nil && msg.Error == nil {
+		msg.Result = json.RawMessage("null")
+	}
+	return msg
+}
+
+func (r *replayer) Close() error {
+	return nil
+}
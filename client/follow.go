@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/celo-org/celo-blockchain"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/celo-blockchain/rpc"
+)
+
+const (
+	followRetryBaseDelay = time.Second
+	followRetryMaxDelay  = time.Minute
+)
+
+// FollowEvent is sent by Follow for every new head and matching log.
+type FollowEvent struct {
+	// Connected is set on the first event after every (re)connect. Heads and
+	// logs of the time the socket was down are not delivered, so followers
+	// resume from their own persisted height.
+	Connected bool
+
+	Head *types.Header
+	Log  *types.Log
+}
+
+// Follow subscribes to newHeads and, when q is not nil, to the logs matching
+// q over the websocket endpoint url. Events are sent on the returned channel
+// until ctx is done, then the channel is closed. Dropped connections are
+// redialed with backoff.
+func Follow(ctx context.Context, url string, q *celo.FilterQuery) <-chan FollowEvent {
+	events := make(chan FollowEvent)
+	go func() {
+		defer close(events)
+		attempt := 0
+		for {
+			subscribed, err := follow(ctx, url, q, events)
+			if ctx.Err() != nil {
+				return
+			}
+			if subscribed {
+				attempt = 0
+			}
+			delay := backoff(attempt, followRetryBaseDelay, followRetryMaxDelay)
+			attempt++
+			log.Warnf("subscription to %s dropped, reconnecting in %v: %v", url, delay, err)
+			if sleep(ctx, delay) != nil {
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// follow runs one websocket connection until it fails. It reports whether the
+// subscriptions were established.
+func follow(ctx context.Context, url string, q *celo.FilterQuery, events chan<- FollowEvent) (bool, error) {
+	rpcClient, err := rpc.DialContext(ctx, url)
+	if err != nil {
+		return false, err
+	}
+	defer rpcClient.Close()
+
+	heads := make(chan *types.Header)
+	headSub, err := rpcClient.EthSubscribe(ctx, heads, "newHeads")
+	if err != nil {
+		return false, err
+	}
+	defer headSub.Unsubscribe()
+
+	logs := make(chan types.Log)
+	var logErr <-chan error
+	if q != nil {
+		arg := map[string]interface{}{
+			"address": q.Addresses,
+			"topics":  q.Topics,
+		}
+		logSub, err := rpcClient.EthSubscribe(ctx, logs, "logs", arg)
+		if err != nil {
+			return false, err
+		}
+		defer logSub.Unsubscribe()
+		logErr = logSub.Err()
+	}
+	log.Infof("subscribed to %s", url)
+
+	connected := true
+	for {
+		var ev FollowEvent
+		select {
+		case head := <-heads:
+			ev.Head = head
+		case l := <-logs:
+			ev.Log = &l
+		case err := <-headSub.Err():
+			return true, fmt.Errorf("newHeads subscription: %v", err)
+		case err := <-logErr:
+			return true, fmt.Errorf("logs subscription: %v", err)
+		case <-ctx.Done():
+			return true, ctx.Err()
+		}
+		ev.Connected = connected
+		connected = false
+
+		select {
+		case events <- ev:
+		case <-ctx.Done():
+			return true, ctx.Err()
+		}
+	}
+}
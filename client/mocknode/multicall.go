@@ -0,0 +1,71 @@
+package mocknode
+
+import (
+	"strings"
+
+	"github.com/celo-org/celo-blockchain/accounts/abi"
+	"github.com/celo-org/celo-blockchain/common"
+)
+
+// aggregate3ABI is the aggregate3 function of Multicall3.
+const aggregate3ABI = `[{"name":"aggregate3","type":"function","stateMutability":"payable",` +
+	`"inputs":[{"name":"calls","type":"tuple[]","components":[` +
+	`{"name":"target","type":"address"},{"name":"allowFailure","type":"bool"},{"name":"callData","type":"bytes"}]}],` +
+	`"outputs":[{"name":"returnData","type":"tuple[]","components":[` +
+	`{"name":"success","type":"bool"},{"name":"returnData","type":"bytes"}]}]}]`
+
+var aggregate3, _ = abi.JSON(strings.NewReader(aggregate3ABI))
+
+type multicall struct {
+	address common.Address
+	from    uint64
+}
+
+type call3 struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+type result struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// SetMulticall deploys a Multicall3 contract at address from block on. Its
+// aggregate3 answers every call like eth_call does.
+func (n *Node) SetMulticall(address common.Address, from uint64) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.multicall = &multicall{address: address, from: from}
+}
+
+// isMulticall reports whether to is the Multicall contract at block number.
+func (n *Node) isMulticall(to common.Address, number uint64) bool {
+	return n.multicall != nil && n.multicall.address == to && number >= n.multicall.from
+}
+
+// aggregate runs the aggregate3 call input at block number. Calls succeed
+// with the scripted output, empty for calls nobody scripted.
+func (n *Node) aggregate(input []byte, number uint64) ([]byte, *rpcError) {
+	if len(input) < 4 {
+		return nil, &rpcError{Code: -32000, Message: "execution reverted"}
+	}
+	args, err := aggregate3.Methods["aggregate3"].Inputs.Unpack(input[4:])
+	if err != nil {
+		return nil, invalidParams(err)
+	}
+	calls := *abi.ConvertType(args[0], new([]call3)).(*[]call3)
+	results := make([]result, len(calls))
+	for i, call := range calls {
+		results[i] = result{Success: true, ReturnData: n.callAt(call.Target, call.CallData, number)}
+		if results[i].ReturnData == nil {
+			results[i].ReturnData = []byte{}
+		}
+	}
+	ret, err := aggregate3.Methods["aggregate3"].Outputs.Pack(results)
+	if err != nil {
+		return nil, &rpcError{Code: -32000, Message: err.Error()}
+	}
+	return ret, nil
+}
@@ -0,0 +1,870 @@
+// Package mocknode provides an in-process fake Celo node for tests. It serves
+// a scripted chain over HTTP JSON-RPC with the Celo block fields, Transfer
+// logs, receipts, callTracer traces, balances and contract calls the indexers
+// read.
+package mocknode
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/celo-org/celo-blockchain/accounts/abi"
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/common/hexutil"
+	"github.com/celo-org/celo-blockchain/contracts/abis"
+	"github.com/celo-org/celo-blockchain/contracts/config"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/celo-org/celo-blockchain/trie"
+)
+
+// TransferTopic is the topic of ERC20 Transfer events.
+var TransferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// Frame is a callTracer frame.
+type Frame struct {
+	Type  string
+	From  common.Address
+	To    common.Address
+	Value *big.Int
+	Error string
+	Calls []*Frame
+}
+
+func (f *Frame) toJSON() map[string]interface{} {
+	m := map[string]interface{}{
+		"type":    f.Type,
+		"from":    strings.ToLower(f.From.Hex()),
+		"to":      strings.ToLower(f.To.Hex()),
+		"gas":     "0x0",
+		"gasUsed": "0x0",
+		"input":   "0x",
+	}
+	if f.Value != nil {
+		m["value"] = hexutil.EncodeBig(f.Value)
+	}
+	if f.Error != "" {
+		m["error"] = f.Error
+	}
+	if len(f.Calls) > 0 {
+		calls := make([]interface{}, len(f.Calls))
+		for i, c := range f.Calls {
+			calls[i] = c.toJSON()
+		}
+		m["calls"] = calls
+	}
+	return m
+}
+
+// Log is an event emitted by a scripted transaction.
+type Log struct {
+	Address common.Address
+	Topics  []common.Hash
+	Data    []byte
+}
+
+// TransferLog returns an ERC20 Transfer event of token.
+func TransferLog(token, from, to common.Address, value *big.Int) *Log {
+	return &Log{
+		Address: token,
+		Topics:  []common.Hash{TransferTopic, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+		Data:    common.LeftPadBytes(value.Bytes(), 32),
+	}
+}
+
+// Tx is a scripted transaction. A nil To is a contract creation.
+type Tx struct {
+	// Type is the transaction type, types.LegacyTxType by default.
+	Type        uint8
+	From        common.Address
+	To          *common.Address
+	Value       *big.Int
+	Data        []byte
+	FeeCurrency *common.Address
+
+	// GasPrice is the gas price of legacy and access list transactions and
+	// the fee cap of dynamic fee ones, GasTipCap their tip cap. Both
+	// default to the values of newTx.
+	GasPrice            *big.Int
+	GasTipCap           *big.Int
+	GatewayFee          *big.Int
+	GatewayFeeRecipient *common.Address
+	// GasUsed and EffectiveGasPrice are served in the receipt, a nil
+	// EffectiveGasPrice is left out like nodes do for pruned state.
+	GasUsed           uint64
+	EffectiveGasPrice *big.Int
+
+	// Trace is the callTracer output. When nil a single frame moving Value
+	// from From to To is served.
+	Trace *Frame
+	Logs  []*Log
+
+	hash common.Hash
+}
+
+// Hash returns the transaction hash, valid once the tx is in a block.
+func (tx *Tx) Hash() common.Hash {
+	return tx.hash
+}
+
+func (tx *Tx) trace() *Frame {
+	if tx.Trace != nil {
+		return tx.Trace
+	}
+	f := &Frame{Type: "CALL", From: tx.From, Value: tx.Value}
+	if tx.To == nil {
+		f.Type = "CREATE"
+	} else {
+		f.To = *tx.To
+	}
+	return f
+}
+
+type block struct {
+	header *types.Header
+	txs    []*Tx
+	raw    []*types.Transaction
+
+	// systemLogs are emitted by the system calls after the transactions.
+	systemLogs []*Log
+}
+
+type balance struct {
+	from  uint64
+	value *big.Int
+}
+
+// output is the return value of a call from block from on.
+type output struct {
+	from uint64
+	data []byte
+}
+
+type failure struct {
+	status  int
+	code    int
+	message string
+	result  json.RawMessage
+	times   int
+}
+
+// Node is an in-process fake Celo node serving a scripted chain.
+type Node struct {
+	URL     string
+	ChainID uint64
+
+	// MaxLogs makes eth_getLogs fail like hosted providers do when a query
+	// matches more logs. Zero means unlimited.
+	MaxLogs int
+	// NoBlockTrace rejects debug_traceBlockByNumber as unsupported.
+	NoBlockTrace bool
+	// OmitTraceTxHash leaves txHash out of block traces like older nodes.
+	OmitTraceTxHash bool
+	// Coinbase is the coinbase of the blocks added from now on.
+	Coinbase common.Address
+
+	server *httptest.Server
+
+	lock     sync.Mutex
+	blocks   []*block
+	forks    int
+	balances map[common.Address][]balance
+	code     map[common.Address]map[string][]output
+	failures map[string][]*failure
+	calls    map[string]int
+
+	// multicall is the Multicall3 contract, nil if none is deployed.
+	multicall *multicall
+}
+
+// New starts a node whose chain holds a genesis block with the given time.
+func New(genesisTime uint64) *Node {
+	n := &Node{
+		ChainID:  42220,
+		balances: make(map[common.Address][]balance),
+		code:     make(map[common.Address]map[string][]output),
+		failures: make(map[string][]*failure),
+		calls:    make(map[string]int),
+	}
+	n.AddBlock(genesisTime)
+	n.server = httptest.NewServer(http.HandlerFunc(n.serveHTTP))
+	n.URL = n.server.URL
+	return n
+}
+
+// Close shuts the server down.
+func (n *Node) Close() {
+	n.server.Close()
+}
+
+// Head returns the number of the newest block.
+func (n *Node) Head() uint64 {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return uint64(len(n.blocks) - 1)
+}
+
+// Header returns the header of the block at number.
+func (n *Node) Header(number uint64) *types.Header {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.blocks[number].header
+}
+
+// AddBlock appends a block with the given time and transactions and returns
+// its header.
+func (n *Node) AddBlock(time uint64, txs ...*Tx) *types.Header {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	number := uint64(len(n.blocks))
+	header := &types.Header{
+		Number:      new(big.Int).SetUint64(number),
+		Time:        time,
+		Extra:       []byte{byte(n.forks)},
+		TxHash:      types.EmptyRootHash,
+		ReceiptHash: types.EmptyRootHash,
+		Root:        common.BigToHash(new(big.Int).SetUint64(number)),
+		Coinbase:    n.Coinbase,
+	}
+	if number > 0 {
+		header.ParentHash = n.blocks[number-1].header.Hash()
+	}
+
+	raw := make([]*types.Transaction, len(txs))
+	for i, tx := range txs {
+		raw[i] = n.newTx(tx, number<<16|uint64(i), append(append([]byte{}, tx.Data...), byte(n.forks)))
+		tx.hash = raw[i].Hash()
+	}
+	if len(raw) > 0 {
+		header.TxHash = types.DeriveSha(types.Transactions(raw), trie.NewStackTrie(nil))
+	}
+	n.blocks = append(n.blocks, &block{header: header, txs: txs, raw: raw})
+	return header
+}
+
+// newTx builds the unsigned transaction of a scripted one.
+func (n *Node) newTx(tx *Tx, nonce uint64, data []byte) *types.Transaction {
+	chainID := new(big.Int).SetUint64(n.ChainID)
+	value := valueOrZero(tx.Value)
+	zero := big.NewInt(0)
+	switch tx.Type {
+	case types.AccessListTxType:
+		return types.NewTx(&types.AccessListTx{
+			ChainID: chainID, Nonce: nonce, GasPrice: orDefault(tx.GasPrice, 1), Gas: 21000,
+			To: tx.To, Value: value, Data: data, V: zero, R: zero, S: zero,
+		})
+	case types.DynamicFeeTxType:
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID: chainID, Nonce: nonce, GasTipCap: orDefault(tx.GasTipCap, 1), GasFeeCap: orDefault(tx.GasPrice, 2), Gas: 21000,
+			To: tx.To, Value: value, Data: data, V: zero, R: zero, S: zero,
+		})
+	case types.CeloDynamicFeeTxType:
+		return types.NewTx(&types.CeloDynamicFeeTx{
+			ChainID: chainID, Nonce: nonce, GasTipCap: orDefault(tx.GasTipCap, 1), GasFeeCap: orDefault(tx.GasPrice, 2), Gas: 21000,
+			FeeCurrency: tx.FeeCurrency, GatewayFeeRecipient: tx.GatewayFeeRecipient, GatewayFee: tx.GatewayFee,
+			To: tx.To, Value: value, Data: data, V: zero, R: zero, S: zero,
+		})
+	default:
+		return types.NewTx(&types.LegacyTx{
+			Nonce: nonce, GasPrice: orDefault(tx.GasPrice, 1), Gas: 21000, FeeCurrency: tx.FeeCurrency,
+			GatewayFeeRecipient: tx.GatewayFeeRecipient, GatewayFee: tx.GatewayFee,
+			To: tx.To, Value: value, Data: data, V: zero, R: zero, S: zero,
+		})
+	}
+}
+
+func orDefault(v *big.Int, def int64) *big.Int {
+	if v == nil {
+		return big.NewInt(def)
+	}
+	return v
+}
+
+// AddSystemLogs adds logs emitted by the system calls of the block at number,
+// like the epoch rewards of an epoch block. They are served in the block
+// receipt and by eth_getLogs with the block hash as transaction hash.
+func (n *Node) AddSystemLogs(number uint64, logs ...*Log) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	b := n.blocks[number]
+	b.systemLogs = append(b.systemLogs, logs...)
+}
+
+// Rewind drops the blocks above number so that the following blocks fork the
+// chain, e.g. to script a reorg.
+func (n *Node) Rewind(number uint64) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.blocks = n.blocks[:number+1]
+	n.forks++
+}
+
+// SetBalance sets the native balance of addr from block on.
+func (n *Node) SetBalance(addr common.Address, from uint64, value *big.Int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	bs := append(n.balances[addr], balance{from: from, value: value})
+	sort.Slice(bs, func(i, j int) bool { return bs[i].from < bs[j].from })
+	n.balances[addr] = bs
+}
+
+// SetCall makes eth_call of the contract to with input return data.
+func (n *Node) SetCall(to common.Address, input, data []byte) {
+	n.SetCallAt(to, input, 0, data)
+}
+
+// SetCallAt makes eth_call of the contract to with input return data from
+// block on.
+func (n *Node) SetCallAt(to common.Address, input []byte, from uint64, data []byte) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.code[to] == nil {
+		n.code[to] = make(map[string][]output)
+	}
+	outputs := append(n.code[to][string(input)], output{from: from, data: data})
+	sort.SliceStable(outputs, func(i, j int) bool { return outputs[i].from < outputs[j].from })
+	n.code[to][string(input)] = outputs
+}
+
+// callAt returns the data a call of to with input returns at block number,
+// nil if nobody scripted it.
+func (n *Node) callAt(to common.Address, input []byte, number uint64) []byte {
+	var data []byte
+	for _, o := range n.code[to][string(input)] {
+		if o.from <= number {
+			data = o.data
+		}
+	}
+	return data
+}
+
+// SetToken makes token answer symbol() and decimals() like an ERC20 token.
+func (n *Node) SetToken(token common.Address, symbol string, decimals uint8) {
+	n.SetCall(token, crypto.Keccak256([]byte("symbol()"))[:4], EncodeString(symbol))
+	n.SetCall(token, crypto.Keccak256([]byte("decimals()"))[:4], common.LeftPadBytes([]byte{decimals}, 32))
+}
+
+// SetTokenBalance makes token answer balanceOf(account) with balance, at any
+// block.
+func (n *Node) SetTokenBalance(token, account common.Address, balance *big.Int) {
+	input := append(crypto.Keccak256([]byte("balanceOf(address)"))[:4], common.LeftPadBytes(account.Bytes(), 32)...)
+	n.SetCall(token, input, common.LeftPadBytes(balance.Bytes(), 32))
+}
+
+// SetLockedGold makes the LockedGold contract at lockedGold answer the
+// position of account, locked CELO and pending withdrawals, from block on.
+func (n *Node) SetLockedGold(lockedGold, account common.Address, from uint64, locked *big.Int, pending ...*big.Int) {
+	arg := common.LeftPadBytes(account.Bytes(), 32)
+	n.SetCallAt(lockedGold, append(crypto.Keccak256([]byte("getAccountTotalLockedGold(address)"))[:4], arg...), from,
+		common.LeftPadBytes(locked.Bytes(), 32))
+
+	uint256s, _ := abi.NewType("uint256[]", "", nil)
+	timestamps := make([]*big.Int, len(pending))
+	for i := range timestamps {
+		timestamps[i] = big.NewInt(0)
+	}
+	if pending == nil {
+		pending = []*big.Int{}
+	}
+	data, err := abi.Arguments{{Type: uint256s}, {Type: uint256s}}.Pack(pending, timestamps)
+	if err != nil {
+		panic(err)
+	}
+	n.SetCallAt(lockedGold, append(crypto.Keccak256([]byte("getPendingWithdrawals(address)"))[:4], arg...), from, data)
+}
+
+// EncodeString returns the ABI encoding of a single string return value.
+func EncodeString(s string) []byte {
+	enc := common.LeftPadBytes([]byte{32}, 32)
+	enc = append(enc, common.LeftPadBytes(new(big.Int).SetInt64(int64(len(s))).Bytes(), 32)...)
+	return append(enc, common.RightPadBytes([]byte(s), (len(s)+31)/32*32)...)
+}
+
+// SetRegistered makes the Celo registry return address for id.
+func (n *Node) SetRegistered(id common.Hash, address common.Address) {
+	input, err := abis.Registry.Pack("getAddressFor", id)
+	if err != nil {
+		panic(err)
+	}
+	n.SetCall(config.RegistrySmartContractAddress, input, common.LeftPadBytes(address.Bytes(), 32))
+}
+
+// SetGasPriceMinimum registers a GasPriceMinimum contract at address that
+// returns gpm for currency.
+func (n *Node) SetGasPriceMinimum(address, currency common.Address, gpm *big.Int) {
+	n.SetRegistered(config.GasPriceMinimumRegistryId, address)
+	input, err := abis.GasPriceMinimum.Pack("getGasPriceMinimum", currency)
+	if err != nil {
+		panic(err)
+	}
+	n.SetCall(address, input, common.LeftPadBytes(gpm.Bytes(), 32))
+}
+
+// FailHTTP makes the next times requests calling method fail with the HTTP
+// status.
+func (n *Node) FailHTTP(method string, status int, times int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.failures[method] = append(n.failures[method], &failure{status: status, times: times})
+}
+
+// FailRPC makes the next times calls of method return a JSON-RPC error.
+func (n *Node) FailRPC(method string, code int, message string, times int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.failures[method] = append(n.failures[method], &failure{code: code, message: message, times: times})
+}
+
+// CorruptResult makes the next times calls of method answer result instead
+// of the real result.
+func (n *Node) CorruptResult(method string, result json.RawMessage, times int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.failures[method] = append(n.failures[method], &failure{result: result, times: times})
+}
+
+// Calls returns how often method was called, batch elements included.
+func (n *Node) Calls(method string) int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.calls[method]
+}
+
+type request struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type response struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+func (n *Node) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var reqs []*request
+	batch := len(body) > 0 && body[0] == '['
+	if batch {
+		err = json.Unmarshal(body, &reqs)
+	} else {
+		var req request
+		err = json.Unmarshal(body, &req)
+		reqs = []*request{&req}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n.lock.Lock()
+	for _, req := range reqs {
+		if f := n.takeFailure(req.Method, true); f != nil {
+			n.lock.Unlock()
+			http.Error(w, http.StatusText(f.status), f.status)
+			return
+		}
+	}
+	resps := make([]*response, len(reqs))
+	for i, req := range reqs {
+		n.calls[req.Method]++
+		resps[i] = &response{Version: "2.0", ID: req.ID}
+		if f := n.takeFailure(req.Method, false); f != nil {
+			if f.result != nil {
+				resps[i].Result = f.result
+			} else {
+				resps[i].Error = &rpcError{Code: f.code, Message: f.message}
+			}
+			continue
+		}
+		result, err := n.handle(req)
+		if err != nil {
+			resps[i].Error = err
+			continue
+		}
+		resps[i].Result = result
+	}
+	n.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if batch {
+		json.NewEncoder(w).Encode(resps)
+	} else {
+		json.NewEncoder(w).Encode(resps[0])
+	}
+}
+
+func (n *Node) takeFailure(method string, http bool) *failure {
+	for _, f := range n.failures[method] {
+		if f.times > 0 && (f.status != 0) == http {
+			f.times--
+			return f
+		}
+	}
+	return nil
+}
+
+// null is returned for results that are not found, it keeps the result field
+// in the response.
+var null = json.RawMessage("null")
+
+func (n *Node) handle(req *request) (interface{}, *rpcError) {
+	switch req.Method {
+	case "eth_chainId":
+		return hexutil.Uint64(n.ChainID), nil
+	case "eth_blockNumber":
+		return hexutil.Uint64(len(n.blocks) - 1), nil
+	case "eth_getBlockByNumber":
+		var full bool
+		if len(req.Params) > 1 {
+			json.Unmarshal(req.Params[1], &full)
+		}
+		b, err := n.blockArg(req.Params[0])
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			return null, nil
+		}
+		return n.blockJSON(b, full), nil
+	case "eth_getLogs":
+		return n.getLogs(req.Params[0])
+	case "eth_getBalance":
+		var addr common.Address
+		if err := json.Unmarshal(req.Params[0], &addr); err != nil {
+			return nil, invalidParams(err)
+		}
+		b, err := n.blockArg(req.Params[1])
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			return nil, &rpcError{Code: -32000, Message: "header not found"}
+		}
+		return (*hexutil.Big)(n.balanceAt(addr, b.header.Number.Uint64())), nil
+	case "eth_call":
+		var arg struct {
+			To    common.Address `json:"to"`
+			Data  hexutil.Bytes  `json:"data"`
+			Input hexutil.Bytes  `json:"input"`
+		}
+		if err := json.Unmarshal(req.Params[0], &arg); err != nil {
+			return nil, invalidParams(err)
+		}
+		if arg.Input != nil {
+			arg.Data = arg.Input
+		}
+		number := n.blocks[len(n.blocks)-1].header.Number.Uint64()
+		if len(req.Params) > 1 {
+			b, err := n.blockArg(req.Params[1])
+			if err != nil {
+				return nil, err
+			}
+			if b == nil {
+				return nil, &rpcError{Code: -32000, Message: "header not found"}
+			}
+			number = b.header.Number.Uint64()
+		}
+		if n.isMulticall(arg.To, number) {
+			ret, err := n.aggregate(arg.Data, number)
+			if err != nil {
+				return nil, err
+			}
+			return hexutil.Bytes(ret), nil
+		}
+		// Calls nobody scripted behave like calls of an account without code.
+		return hexutil.Bytes(n.callAt(arg.To, arg.Data, number)), nil
+	case "eth_getTransactionReceipt":
+		var hash common.Hash
+		if err := json.Unmarshal(req.Params[0], &hash); err != nil {
+			return nil, invalidParams(err)
+		}
+		for _, b := range n.blocks {
+			for i, tx := range b.txs {
+				if tx.hash == hash {
+					return n.receiptJSON(b, i), nil
+				}
+			}
+		}
+		return null, nil
+	case "eth_getBlockReceipt":
+		var hash common.Hash
+		if err := json.Unmarshal(req.Params[0], &hash); err != nil {
+			return nil, invalidParams(err)
+		}
+		for _, b := range n.blocks {
+			if b.header.Hash() == hash {
+				return n.blockReceiptJSON(b), nil
+			}
+		}
+		return null, nil
+	case "debug_traceTransaction":
+		var hash common.Hash
+		if err := json.Unmarshal(req.Params[0], &hash); err != nil {
+			return nil, invalidParams(err)
+		}
+		for _, b := range n.blocks {
+			for _, tx := range b.txs {
+				if tx.hash == hash {
+					return tx.trace().toJSON(), nil
+				}
+			}
+		}
+		return nil, &rpcError{Code: -32000, Message: fmt.Sprintf("transaction %x not found", hash)}
+	case "debug_traceBlockByNumber":
+		if n.NoBlockTrace {
+			return nil, &rpcError{Code: -32601, Message: "the method debug_traceBlockByNumber does not exist/is not available"}
+		}
+		b, err := n.blockArg(req.Params[0])
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			return nil, &rpcError{Code: -32000, Message: "block not found"}
+		}
+		results := make([]map[string]interface{}, len(b.txs))
+		for i, tx := range b.txs {
+			results[i] = map[string]interface{}{"result": tx.trace().toJSON()}
+			if !n.OmitTraceTxHash {
+				results[i]["txHash"] = tx.hash
+			}
+		}
+		return results, nil
+	}
+	return nil, &rpcError{Code: -32601, Message: fmt.Sprintf("the method %s does not exist/is not available", req.Method)}
+}
+
+func invalidParams(err error) *rpcError {
+	return &rpcError{Code: -32602, Message: err.Error()}
+}
+
+// blockArg resolves a block number parameter, nil if it is beyond the head.
+func (n *Node) blockArg(param json.RawMessage) (*block, *rpcError) {
+	var arg string
+	if err := json.Unmarshal(param, &arg); err != nil {
+		return nil, invalidParams(err)
+	}
+	if arg == "latest" || arg == "pending" {
+		return n.blocks[len(n.blocks)-1], nil
+	}
+	if arg == "earliest" {
+		return n.blocks[0], nil
+	}
+	number, err := hexutil.DecodeUint64(arg)
+	if err != nil {
+		return nil, invalidParams(err)
+	}
+	if number >= uint64(len(n.blocks)) {
+		return nil, nil
+	}
+	return n.blocks[number], nil
+}
+
+func (n *Node) blockJSON(b *block, full bool) map[string]interface{} {
+	var m map[string]interface{}
+	enc, _ := json.Marshal(b.header)
+	json.Unmarshal(enc, &m)
+
+	txs := make([]interface{}, len(b.txs))
+	for i, tx := range b.txs {
+		if full {
+			txs[i] = n.txJSON(b, i)
+		} else {
+			txs[i] = tx.hash
+		}
+	}
+	m["transactions"] = txs
+	m["randomness"] = &types.Randomness{
+		Revealed:  crypto.Keccak256Hash(b.header.Number.Bytes()),
+		Committed: crypto.Keccak256Hash(b.header.ParentHash.Bytes()),
+	}
+	m["epochSnarkData"] = &types.EpochSnarkData{Bitmap: big.NewInt(0), Signature: []byte{}}
+	return m
+}
+
+func (n *Node) txJSON(b *block, i int) map[string]interface{} {
+	var m map[string]interface{}
+	enc, _ := json.Marshal(b.raw[i])
+	json.Unmarshal(enc, &m)
+	m["from"] = b.txs[i].From
+	m["blockHash"] = b.header.Hash()
+	m["blockNumber"] = (*hexutil.Big)(b.header.Number)
+	m["transactionIndex"] = hexutil.Uint64(i)
+	return m
+}
+
+func (n *Node) receiptJSON(b *block, i int) map[string]interface{} {
+	tx := b.txs[i]
+	logs := make([]*types.Log, 0)
+	for _, l := range n.blockLogs(b) {
+		if l.TxHash == tx.hash {
+			logs = append(logs, l)
+		}
+	}
+	m := map[string]interface{}{
+		"transactionHash":   tx.hash,
+		"transactionIndex":  hexutil.Uint64(i),
+		"blockHash":         b.header.Hash(),
+		"blockNumber":       (*hexutil.Big)(b.header.Number),
+		"from":              tx.From,
+		"to":                tx.To,
+		"gasUsed":           hexutil.Uint64(tx.GasUsed),
+		"cumulativeGasUsed": hexutil.Uint64(tx.GasUsed),
+		"logs":              logs,
+		"logsBloom":         types.Bloom{},
+		"status":            hexutil.Uint(types.ReceiptStatusSuccessful),
+		"type":              hexutil.Uint(b.raw[i].Type()),
+	}
+	if tx.EffectiveGasPrice != nil {
+		m["effectiveGasPrice"] = (*hexutil.Big)(tx.EffectiveGasPrice)
+	}
+	return m
+}
+
+// blockLogs returns the logs of b, those of the system calls last.
+func (n *Node) blockLogs(b *block) []*types.Log {
+	var logs []*types.Log
+	add := func(l *Log, txHash common.Hash, txIndex int) {
+		logs = append(logs, &types.Log{
+			Address:     l.Address,
+			Topics:      l.Topics,
+			Data:        l.Data,
+			BlockNumber: b.header.Number.Uint64(),
+			TxHash:      txHash,
+			TxIndex:     uint(txIndex),
+			BlockHash:   b.header.Hash(),
+			Index:       uint(len(logs)),
+		})
+	}
+	for i, tx := range b.txs {
+		for _, l := range tx.Logs {
+			add(l, tx.hash, i)
+		}
+	}
+	for _, l := range b.systemLogs {
+		add(l, b.header.Hash(), len(b.txs))
+	}
+	return logs
+}
+
+func (n *Node) blockReceiptJSON(b *block) map[string]interface{} {
+	logs := make([]*types.Log, 0)
+	for _, l := range n.blockLogs(b) {
+		if l.TxHash == b.header.Hash() {
+			logs = append(logs, l)
+		}
+	}
+	return map[string]interface{}{
+		"transactionHash":   b.header.Hash(),
+		"transactionIndex":  hexutil.Uint64(len(b.txs)),
+		"blockHash":         b.header.Hash(),
+		"blockNumber":       (*hexutil.Big)(b.header.Number),
+		"from":              common.Address{},
+		"to":                nil,
+		"gasUsed":           hexutil.Uint64(0),
+		"cumulativeGasUsed": hexutil.Uint64(0),
+		"logs":              logs,
+		"logsBloom":         types.Bloom{},
+		"status":            hexutil.Uint(types.ReceiptStatusSuccessful),
+		"type":              hexutil.Uint(0),
+	}
+}
+
+type filterArg struct {
+	FromBlock string           `json:"fromBlock"`
+	ToBlock   string           `json:"toBlock"`
+	Address   []common.Address `json:"address"`
+	Topics    [][]common.Hash  `json:"topics"`
+}
+
+func (n *Node) getLogs(param json.RawMessage) (interface{}, *rpcError) {
+	var arg filterArg
+	if err := json.Unmarshal(param, &arg); err != nil {
+		return nil, invalidParams(err)
+	}
+	from, to := uint64(0), uint64(len(n.blocks)-1)
+	if arg.FromBlock != "" && arg.FromBlock != "latest" {
+		from, _ = hexutil.DecodeUint64(arg.FromBlock)
+	}
+	if arg.ToBlock != "" && arg.ToBlock != "latest" {
+		to, _ = hexutil.DecodeUint64(arg.ToBlock)
+	}
+
+	logs := make([]*types.Log, 0)
+	for number := from; number <= to && number < uint64(len(n.blocks)); number++ {
+		for _, l := range n.blockLogs(n.blocks[number]) {
+			if matchLog(&Log{Address: l.Address, Topics: l.Topics}, arg.Address, arg.Topics) {
+				logs = append(logs, l)
+			}
+		}
+	}
+	if n.MaxLogs > 0 && len(logs) > n.MaxLogs {
+		return nil, &rpcError{Code: -32005, Message: fmt.Sprintf("query returned more than %d results", n.MaxLogs)}
+	}
+	return logs, nil
+}
+
+func matchLog(l *Log, addresses []common.Address, topics [][]common.Hash) bool {
+	if len(addresses) > 0 {
+		found := false
+		for _, a := range addresses {
+			found = found || a == l.Address
+		}
+		if !found {
+			return false
+		}
+	}
+	for i, alternatives := range topics {
+		if len(alternatives) == 0 {
+			continue
+		}
+		if i >= len(l.Topics) {
+			return false
+		}
+		found := false
+		for _, t := range alternatives {
+			found = found || t == l.Topics[i]
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *Node) balanceAt(addr common.Address, number uint64) *big.Int {
+	value := new(big.Int)
+	for _, b := range n.balances[addr] {
+		if b.from <= number {
+			value = b.value
+		}
+	}
+	return value
+}
+
+func valueOrZero(v *big.Int) *big.Int {
+	if v == nil {
+		return new(big.Int)
+	}
+	return v
+}
@@ -0,0 +1,321 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common/hexutil"
+	"github.com/celo-org/celo-blockchain/rpc"
+)
+
+// healthCheckTimeout bounds a single health probe of an endpoint.
+const healthCheckTimeout = 10 * time.Second
+
+var errNoEndpoint = errors.New("no endpoint available")
+
+// Endpoint describes one JSON-RPC endpoint of a pool.
+type Endpoint struct {
+	URL     string
+	Weight  int
+	Archive bool // the endpoint serves historical state and traces
+}
+
+type endpoint struct {
+	Endpoint
+	rpcClient *rpc.Client
+
+	healthy bool
+	head    uint64
+}
+
+// pool spreads calls over several endpoints. Endpoints are health-checked
+// periodically with eth_chainId and eth_blockNumber and are ejected when they
+// fail, report a different chain or lag behind the best head by more than
+// maxLag blocks. Each call fails over to the next endpoint on transport
+// errors.
+//
+// Calls that fail transiently on every endpoint are retried with jittered
+// exponential backoff, and all calls are paced by an optional rate limiter.
+type pool struct {
+	lock      sync.Mutex
+	endpoints []*endpoint
+	chainID   *big.Int
+	opts      poolOptions
+	limiter   *rateLimiter
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+type poolOptions struct {
+	maxLag              uint64
+	healthCheckInterval time.Duration // zero disables health checks
+
+	maxRetries     int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+
+	requestsPerSecond float64 // zero disables rate limiting
+	requestBurst      int
+
+	// transport carries the HTTP requests of every endpoint when set.
+	transport http.RoundTripper
+}
+
+func newPool(ctx context.Context, endpoints []Endpoint, opts poolOptions) (*pool, error) {
+	if len(endpoints) == 0 {
+		return nil, errNoEndpoint
+	}
+	p := &pool{
+		opts:    opts,
+		limiter: newRateLimiter(opts.requestsPerSecond, opts.requestBurst),
+		quit:    make(chan struct{}),
+	}
+	for _, e := range endpoints {
+		var rpcClient *rpc.Client
+		var err error
+		if opts.transport != nil {
+			rpcClient, err = rpc.DialHTTPWithClient(e.URL, &http.Client{Transport: opts.transport})
+		} else {
+			rpcClient, err = rpc.DialContext(ctx, e.URL)
+		}
+		if err != nil {
+			p.close()
+			return nil, fmt.Errorf("dial %s err: %v", e.URL, err)
+		}
+		if e.Weight <= 0 {
+			e.Weight = 1
+		}
+		p.endpoints = append(p.endpoints, &endpoint{
+			Endpoint:  e,
+			rpcClient: rpcClient,
+			healthy:   true,
+		})
+	}
+
+	if opts.healthCheckInterval > 0 {
+		p.checkHealth()
+		p.wg.Add(1)
+		go p.healthLoop(opts.healthCheckInterval)
+	}
+	return p, nil
+}
+
+func (p *pool) close() {
+	select {
+	case <-p.quit:
+		return
+	default:
+		close(p.quit)
+	}
+	p.wg.Wait()
+	for _, e := range p.endpoints {
+		e.rpcClient.Close()
+	}
+}
+
+func (p *pool) healthLoop(interval time.Duration) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.checkHealth()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+type probeResult struct {
+	chainID *big.Int
+	head    uint64
+	err     error
+}
+
+func probe(e *endpoint) probeResult {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	var chainID hexutil.Big
+	if err := e.rpcClient.CallContext(ctx, &chainID, "eth_chainId"); err != nil {
+		return probeResult{err: err}
+	}
+	var head hexutil.Uint64
+	if err := e.rpcClient.CallContext(ctx, &head, "eth_blockNumber"); err != nil {
+		return probeResult{err: err}
+	}
+	return probeResult{chainID: (*big.Int)(&chainID), head: uint64(head)}
+}
+
+// checkHealth probes every endpoint and updates which ones are in rotation.
+func (p *pool) checkHealth() {
+	results := make([]probeResult, len(p.endpoints))
+	var wg sync.WaitGroup
+	for i, e := range p.endpoints {
+		wg.Add(1)
+		go func(i int, e *endpoint) {
+			defer wg.Done()
+			results[i] = probe(e)
+		}(i, e)
+	}
+	wg.Wait()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	best := uint64(0)
+	for _, r := range results {
+		if r.err != nil {
+			continue
+		}
+		if p.chainID == nil {
+			p.chainID = r.chainID
+		}
+		if r.chainID.Cmp(p.chainID) == 0 && r.head > best {
+			best = r.head
+		}
+	}
+
+	for i, e := range p.endpoints {
+		r := results[i]
+		var reason error
+		switch {
+		case r.err != nil:
+			reason = r.err
+		case r.chainID.Cmp(p.chainID) != 0:
+			reason = fmt.Errorf("chain id %v, want %v", r.chainID, p.chainID)
+		case p.opts.maxLag > 0 && r.head+p.opts.maxLag < best:
+			reason = fmt.Errorf("head %d lags best head %d", r.head, best)
+		}
+		if r.err == nil {
+			e.head = r.head
+		}
+		if reason != nil {
+			if e.healthy {
+				log.Warnf("endpoint %s ejected: %v", e.URL, reason)
+			}
+			e.healthy = false
+			continue
+		}
+		if !e.healthy {
+			log.Infof("endpoint %s back in rotation at head %d", e.URL, e.head)
+		}
+		e.healthy = true
+	}
+}
+
+// candidates returns the endpoints to try for a call in order. Healthy
+// endpoints come first in a weighted random order, followed by ejected ones
+// as a last resort. Archive calls only consider archive endpoints.
+func (p *pool) candidates(archive bool) []*endpoint {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var healthy, ejected []*endpoint
+	for _, e := range p.endpoints {
+		if archive && !e.Archive {
+			continue
+		}
+		if e.healthy {
+			healthy = append(healthy, e)
+		} else {
+			ejected = append(ejected, e)
+		}
+	}
+	return append(weightedShuffle(healthy), ejected...)
+}
+
+func weightedShuffle(endpoints []*endpoint) []*endpoint {
+	total := 0
+	for _, e := range endpoints {
+		total += e.Weight
+	}
+	ordered := make([]*endpoint, 0, len(endpoints))
+	rest := append([]*endpoint(nil), endpoints...)
+	for len(rest) > 0 {
+		n := rand.Intn(total)
+		i := 0
+		for ; n >= rest[i].Weight; i++ {
+			n -= rest[i].Weight
+		}
+		ordered = append(ordered, rest[i])
+		total -= rest[i].Weight
+		rest = append(rest[:i], rest[i+1:]...)
+	}
+	return ordered
+}
+
+func (p *pool) eject(e *endpoint, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if e.healthy {
+		log.Warnf("endpoint %s ejected: %v", e.URL, err)
+	}
+	e.healthy = false
+}
+
+// call runs fn against the candidate endpoints until one of them answers.
+// Endpoints that fail are ejected and rate-limited ones are skipped. Errors
+// returned by the node itself are passed through without failover. When
+// every endpoint failed transiently the whole round is retried after a
+// backoff. cost is the number of requests fn sends, for rate limiting.
+func (p *pool) call(ctx context.Context, archive bool, cost int, fn func(*rpc.Client) error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = p.callOnce(ctx, archive, cost, fn)
+		if !isTransient(ctx, err) || attempt >= p.opts.maxRetries {
+			return err
+		}
+		delay := backoff(attempt, p.opts.retryBaseDelay, p.opts.retryMaxDelay)
+		log.Debugf("retrying in %v after error: %v", delay, err)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *pool) callOnce(ctx context.Context, archive bool, cost int, fn func(*rpc.Client) error) error {
+	endpoints := p.candidates(archive)
+	if len(endpoints) == 0 {
+		if archive {
+			return fmt.Errorf("%w: no archive endpoint configured", errNoEndpoint)
+		}
+		return errNoEndpoint
+	}
+
+	var err error
+	for _, e := range endpoints {
+		if err := p.limiter.wait(ctx, cost); err != nil {
+			return err
+		}
+		err = fn(e.rpcClient)
+		switch {
+		case err == nil:
+			return nil
+		case isRateLimited(err):
+			log.Debugf("endpoint %s rate limited: %v", e.URL, err)
+		case isEndpointError(ctx, err):
+			p.eject(e, err)
+		default:
+			return err
+		}
+	}
+	return err
+}
+
+// isEndpointError reports whether err is a failure of the endpoint rather
+// than an answer from the node, so that the call may be tried elsewhere. An
+// answer that fails to decode would fail on every endpoint alike.
+func isEndpointError(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && isTransportError(err)
+}
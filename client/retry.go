@@ -0,0 +1,229 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/rpc"
+)
+
+// rateLimitCodes are JSON-RPC error codes providers use to signal that the
+// request rate or a request limit was exceeded.
+var rateLimitCodes = map[int]bool{
+	limitExceededCode: true,
+	-32029:            true,
+	-32090:            true,
+	429:               true,
+}
+
+// rateLimitMessages match rate-limit errors of providers that use a generic
+// error code.
+var rateLimitMessages = []string{
+	"rate limit",
+	"too many requests",
+	"request limit",
+	"exceeded the rpc",
+	"capacity exceeded",
+}
+
+// limitExceededCode is the EIP-1474 error code of requests exceeding a limit
+// of the node.
+const limitExceededCode = -32005
+
+// tooManyLogsMessages match errors of nodes and providers refusing an
+// eth_getLogs query because its range holds too many results or too much data.
+// They are kept specific, so that invalid ranges are not mistaken for them.
+var tooManyLogsMessages = []string{
+	"query returned more than", // geth, Infura
+	"response size exceeded",   // Alchemy
+	"response size should not", // Alchemy
+	"too many results",
+	"too many logs",
+	"exceeds max results",
+	"block range is too wide", // Ankr
+	"block range too large",
+	"block range limit exceeded", // Chainstack
+	"exceed maximum block range",
+	"eth_getlogs is limited to",                    // QuickNode
+	"eth_getlogs and eth_newfilter are limited to", // QuickNode
+}
+
+// isTooManyLogs reports whether an eth_getLogs query failed because its block
+// range was too large for the node. A limit exceeded error is enough when it
+// is about the range.
+func isTooManyLogs(err error) bool {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	msg := strings.ToLower(rpcErr.Error())
+	if rpcErr.ErrorCode() == limitExceededCode && strings.Contains(msg, "range") {
+		return true
+	}
+	for _, m := range tooManyLogsMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRateLimited reports whether the node answered err because of rate limits.
+// Some providers use the limit exceeded code for oversized log queries too,
+// those are not rate limits.
+func isRateLimited(err error) bool {
+	if isTooManyLogs(err) {
+		return false
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	if rateLimitCodes[rpcErr.ErrorCode()] {
+		return true
+	}
+	msg := strings.ToLower(rpcErr.Error())
+	for _, m := range rateLimitMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransient reports whether err is worth retrying: timeouts, dropped
+// connections, HTTP 429 and 5xx responses and rate-limit errors. Errors
+// returned by the node or raised decoding its answer are final.
+func isTransient(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil || errors.Is(err, errNoEndpoint) {
+		return false
+	}
+	if isRateLimited(err) {
+		return true
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	// DNS, TLS and other failures of the transport are worth another try,
+	// errors decoding an answer are not.
+	return isTransportError(err)
+}
+
+// isTransportError reports whether err was raised while carrying the call to
+// the endpoint and back rather than by the node or by decoding its answer.
+func isTransportError(err error) bool {
+	var httpErr rpc.HTTPError
+	var netErr net.Error
+	var urlErr *url.Error
+	var errno syscall.Errno
+	return errors.As(err, &httpErr) || errors.As(err, &netErr) || errors.As(err, &urlErr) ||
+		errors.As(err, &errno) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// backoff returns the jittered delay before retry attempt n, counting from 0.
+func backoff(n int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 0; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	// Equal jitter keeps at least half of the delay.
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimiter is a token bucket refilled at rate tokens per second holding at
+// most burst tokens.
+type rateLimiter struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(rate)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until n requests may be sent. Requests larger than the bucket
+// are charged a full bucket. A nil limiter never blocks.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	need := float64(n)
+	if need > l.burst {
+		need = l.burst
+	}
+	for {
+		l.lock.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+		if l.tokens >= need {
+			l.tokens -= need
+			l.lock.Unlock()
+			return nil
+		}
+		delay := time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+		l.lock.Unlock()
+
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// BlockTimes resolves block numbers to block timestamps. Timestamps are kept
+// in an LRU cache and missing ones are fetched as headers in one batch, so
+// every block costs at most one header request however many logs it holds.
+type BlockTimes struct {
+	cli   *Client
+	cache *lru.Cache
+	lock  sync.Mutex
+}
+
+func NewBlockTimes(cli *Client, size int) (*BlockTimes, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockTimes{
+		cli:   cli,
+		cache: cache,
+	}, nil
+}
+
+// Resolve returns the timestamps of the given blocks keyed by block number.
+func (t *BlockTimes) Resolve(ctx context.Context, numbers []uint64) (map[uint64]uint64, error) {
+	// Serialize resolves so concurrent callers don't fetch the same headers.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	times := make(map[uint64]uint64, len(numbers))
+	missing := make([]*big.Int, 0)
+	for _, number := range numbers {
+		if _, ok := times[number]; ok {
+			continue
+		}
+		if v, ok := t.cache.Get(number); ok {
+			times[number] = v.(uint64)
+			continue
+		}
+		// Mark as seen, the value is filled in below.
+		times[number] = 0
+		missing = append(missing, new(big.Int).SetUint64(number))
+	}
+	if len(missing) == 0 {
+		return times, nil
+	}
+
+	headers, errs := t.cli.HeadersByNumber(ctx, missing)
+	for i, header := range headers {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("header %v err: %v", missing[i], errs[i])
+		}
+		number := missing[i].Uint64()
+		times[number] = header.Time
+		t.cache.Add(number, header.Time)
+	}
+	return times, nil
+}
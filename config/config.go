@@ -9,10 +9,20 @@ import (
 	"os/user"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common"
 )
 
 var DefaultConfigFilename = "config.json"
 
+// EndpointConfig is one JSON-RPC endpoint of the client pool.
+type EndpointConfig struct {
+	URL     string `json:"url"`
+	Weight  int    `json:"weight,omitempty"`  // Relative share of calls, defaults to 1
+	Archive bool   `json:"archive,omitempty"` // Serves traces and historical state
+}
+
 type Config struct {
 	DebugLevel     string `json:"debugLevel,omitempty"` // Logging level for all subsystems {trace, debug, info, warn, error, critical}
 	LogDir         string `json:"logDir,omitempty"`
@@ -23,6 +33,28 @@ type Config struct {
 	StartBlock uint64 `json:"startBlock,omitempty"`
 	EndBlock   uint64 `json:"endBlock,omitempty"`
 
+	TokenRegistry string `json:"tokenRegistry,omitempty"` // JSON file listing the tracked ERC20 tokens
+
+	LogRangeSize    uint64 `json:"logRangeSize,omitempty"`    // Initial block range of eth_getLogs queries
+	MaxLogRangeSize uint64 `json:"maxLogRangeSize,omitempty"` // Block range the log window may grow to
+
+	WS            string `json:"ws,omitempty"`            // Websocket endpoint used in follow mode
+	Follow        bool   `json:"follow,omitempty"`        // Keep indexing new blocks after the backfill
+	Confirmations uint64 `json:"confirmations,omitempty"` // Blocks a block has to be buried under before it is indexed
+
+	Endpoints           []EndpointConfig `json:"endpoints,omitempty"`           // Used instead of HTTP when set
+	MaxBlockLag         uint64           `json:"maxBlockLag,omitempty"`         // Eject endpoints lagging the best head by more blocks
+	HealthCheckInterval int              `json:"healthCheckInterval,omitempty"` // Endpoint health check interval in seconds
+
+	MaxRetries        int     `json:"maxRetries,omitempty"`        // Retries of transient RPC failures, negative disables retries
+	RetryBaseDelay    int     `json:"retryBaseDelay,omitempty"`    // First retry delay in milliseconds, doubled per retry
+	RetryMaxDelay     int     `json:"retryMaxDelay,omitempty"`     // Maximum retry delay in milliseconds
+	RequestsPerSecond float64 `json:"requestsPerSecond,omitempty"` // RPC request rate limit, 0 for unlimited
+	RequestBurst      int     `json:"requestBurst,omitempty"`      // Requests allowed in a burst, defaults to requestsPerSecond
+
+	RecordFixture string `json:"recordFixture,omitempty"` // Append every JSON-RPC call and answer to this file
+	ReplayFixture string `json:"replayFixture,omitempty"` // Answer JSON-RPC calls from this file instead of the network
+
 	PostgresDBName   string `json:"postgresDBName,omitempty"`
 	PostgresHost     string `json:"postgresHost,omitempty"`
 	PostgresPort     uint32 `json:"postgresPort,omitempty"`
@@ -36,17 +68,39 @@ type Config struct {
 	StatisticsDateEnd   string `json:"statisticsDateEnd,omitempty"`
 
 	CoinHistoryPrice string `json:"coinPriceHistory,omitempty"`
+
+	SnapshotBlock      uint64 `json:"snapshotBlock,omitempty"`      // Snapshot the balances at this block instead of indexing
+	SnapshotAddresses  string `json:"snapshotAddresses,omitempty"`  // File listing the accounts to snapshot, defaults to every account seen in the records
+	Multicall          string `json:"multicall,omitempty"`          // Multicall3 contract batching the balanceOf calls of snapshots
+	MulticallBatchSize int    `json:"multicallBatchSize,omitempty"` // Calls per eth_call of the Multicall contract
+
+	AuditDate   string `json:"auditDate,omitempty"`   // Audit the balances computed for this day against the chain instead of indexing
+	AuditSample int    `json:"auditSample,omitempty"` // Accounts audited, 0 for all of them
+	AuditReport string `json:"auditReport,omitempty"` // CSV discrepancy report, defaults to audit_YYYYMMDD.csv
 }
 
 func DefaultConfig() Config {
 	return Config{
-		DebugLevel:       "Info",
-		LogDir:           "",
-		MaxLogFiles:      1,
-		MaxLogFileSize:   100,
-		HTTP:             "https://solitary-responsive-putty.celo-mainnet.quiknode.pro/40a3938f2f03f6ae973996eccf6106a9ab27c418",
-		StartBlock:       0,
-		EndBlock:         0,
+		DebugLevel:     "Info",
+		LogDir:         "",
+		MaxLogFiles:    1,
+		MaxLogFileSize: 100,
+		HTTP:           "https://solitary-responsive-putty.celo-mainnet.quiknode.pro/40a3938f2f03f6ae973996eccf6106a9ab27c418",
+		StartBlock:     0,
+		EndBlock:       0,
+
+		TokenRegistry: "tokens.json",
+
+		LogRangeSize:    10000,
+		MaxLogRangeSize: 100000,
+		Confirmations:   10,
+
+		MaxBlockLag:         20,
+		HealthCheckInterval: 30,
+		MaxRetries:          5,
+		RetryBaseDelay:      500,
+		RetryMaxDelay:       30000,
+
 		PostgresDBName:   "",
 		PostgresHost:     "",
 		PostgresPort:     5432,
@@ -58,6 +112,9 @@ func DefaultConfig() Config {
 
 		StatisticsDateBegin: "",
 		StatisticsDateEnd:   "",
+
+		Multicall:          "0xcA11bde05977b3631167028862bE2a173976CA11",
+		MulticallBatchSize: 500,
 	}
 }
 
@@ -83,6 +140,32 @@ func LoadConfig() (*Config, error) {
 func (cfg *Config) ValidateConfig() error {
 	cfg.LogDir = CleanAndExpandPath(cfg.LogDir)
 
+	if cfg.HTTP == "" && len(cfg.Endpoints) == 0 && cfg.ReplayFixture == "" {
+		return errors.New("HTTP and Endpoints are empty")
+	}
+	for _, e := range cfg.Endpoints {
+		if e.URL == "" {
+			return errors.New("endpoint url is empty")
+		}
+		if e.Weight < 0 {
+			return errors.New("endpoint weight is negative")
+		}
+	}
+	if cfg.RequestsPerSecond < 0 {
+		return errors.New("RequestsPerSecond is negative")
+	}
+	if cfg.RecordFixture != "" && cfg.ReplayFixture != "" {
+		return errors.New("RecordFixture and ReplayFixture are both set")
+	}
+
+	if cfg.TokenRegistry == "" {
+		return errors.New("TokenRegistry is empty")
+	}
+
+	if cfg.Follow && cfg.WS == "" {
+		return errors.New("WS is empty in follow mode")
+	}
+
 	if cfg.PullStartHeight > cfg.PullEndHeight {
 		return errors.New("pull start height is smaller to pull end height")
 	}
@@ -102,6 +185,21 @@ func (cfg *Config) ValidateConfig() error {
 	if cfg.CoinHistoryPrice == "" {
 		return errors.New("CoinHistoryPrice is empty")
 	}
+
+	if !common.IsHexAddress(cfg.Multicall) {
+		return errors.New("Multicall is not an address")
+	}
+	if cfg.MulticallBatchSize <= 0 {
+		return errors.New("MulticallBatchSize is not positive")
+	}
+	if cfg.AuditDate != "" {
+		if _, err := time.Parse("2006-01-02", cfg.AuditDate); err != nil {
+			return errors.New("AuditDate time format error " + err.Error())
+		}
+	}
+	if cfg.AuditSample < 0 {
+		return errors.New("AuditSample is negative")
+	}
 	return nil
 }
 
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+const blocksTable = "indexed_blocks"
+
+// CreateBlockTable creates the table holding the hash and parent hash of the
+// blocks each indexer has processed.
+func (p *PostgresDB) CreateBlockTable() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	createTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+		"indexer VARCHAR(16),"+
+		"number BIGINT,"+
+		"hash VARCHAR(66),"+
+		"parenthash VARCHAR(66),"+
+		"PRIMARY KEY (indexer, number)"+
+		");", blocksTable)
+	_, err := p.db.Exec(createTableSQL)
+	if err != nil {
+		return errors.New(fmt.Sprintf("create sql table %s err: %v", blocksTable, err))
+	}
+	return nil
+}
+
+// insertBlocks records blocks processed by indexer, replacing earlier entries
+// of the same height.
+func insertBlocks(tx *sql.Tx, indexer string, blocks []*types.BlockRef) error {
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (indexer, number, hash, parenthash) VALUES ($1,$2,$3,$4) "+
+		"ON CONFLICT (indexer, number) DO UPDATE SET hash = EXCLUDED.hash, parenthash = EXCLUDED.parenthash", blocksTable))
+	if err != nil {
+		return errors.New(fmt.Sprintf("db prepare err: %v", err))
+	}
+	defer stmt.Close()
+
+	for _, b := range blocks {
+		if _, err := stmt.Exec(indexer, b.Number, b.Hash.String(), b.ParentHash.String()); err != nil {
+			return errors.New(fmt.Sprintf("db stmt exec err: %v", err))
+		}
+	}
+	return nil
+}
+
+// GetBlockHash returns the recorded hash of the block at number. The boolean
+// is false when indexer has not recorded that height.
+func (p *PostgresDB) GetBlockHash(indexer string, number uint64) (common.Hash, bool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var hash string
+	query := fmt.Sprintf("SELECT hash FROM %s WHERE indexer = $1 AND number = $2", blocksTable)
+	err := p.db.QueryRow(query, indexer, number).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return common.Hash{}, false, nil
+	}
+	if err != nil {
+		return common.Hash{}, false, err
+	}
+	return common.HexToHash(hash), true, nil
+}
+
+// PruneBlocks removes the recorded blocks of indexer below number.
+func (p *PostgresDB) PruneBlocks(indexer string, number uint64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	_, err := p.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE indexer = $1 AND number < $2", blocksTable), indexer, number)
+	return err
+}
+
+// deleteRecords removes the records above block number from the daily record
+// tables named prefix+YYYYMMDD of day sinceDate and later.
+func deleteRecords(tx *sql.Tx, prefix string, sinceDate string, number uint64) error {
+	tables, err := recordTables(tx, prefix, sinceDate)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		res, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE blocknumber > $1", table), number)
+		if err != nil {
+			return errors.New(fmt.Sprintf("delete from %s err: %v", table, err))
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Infof("deleted %d records above block %d from %s", n, number, table)
+		}
+	}
+	return nil
+}
+
+// recordTables lists the daily record tables named prefix+YYYYMMDD of day
+// sinceDate and later.
+func recordTables(db queryer, prefix string, sinceDate string) ([]string, error) {
+	rows, err := db.Query("SELECT table_name FROM information_schema.tables WHERE table_schema = 'public' AND table_name LIKE $1",
+		strings.ReplaceAll(prefix, "_", `\_`)+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		date := strings.TrimPrefix(name, prefix)
+		if len(date) == len("20060102") && date >= sinceDate {
+			tables = append(tables, name)
+		}
+	}
+	return tables, rows.Err()
+}
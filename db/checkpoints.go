@@ -0,0 +1,147 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+const checkpointsTable = "indexer_checkpoints"
+
+// CreateCheckpointTable creates the table holding the height each indexer
+// has stored completely.
+func (p *PostgresDB) CreateCheckpointTable() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	createTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+		"indexer VARCHAR(16) PRIMARY KEY,"+
+		"height BIGINT,"+
+		"updated TIMESTAMP"+
+		");", checkpointsTable)
+	_, err := p.db.Exec(createTableSQL)
+	if err != nil {
+		return errors.New(fmt.Sprintf("create sql table %s err: %v", checkpointsTable, err))
+	}
+	return nil
+}
+
+// GetCheckpoint returns the checkpoint of indexer. The boolean is false when
+// indexer has none yet.
+func (p *PostgresDB) GetCheckpoint(indexer string) (uint64, bool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var height uint64
+	query := fmt.Sprintf("SELECT height FROM %s WHERE indexer = $1", checkpointsTable)
+	err := p.db.QueryRow(query, indexer).Scan(&height)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return height, true, nil
+}
+
+// SetCheckpoint sets the checkpoint of indexer.
+func (p *PostgresDB) SetCheckpoint(indexer string, height uint64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return setCheckpoint(p.db, indexer, height)
+}
+
+func setCheckpoint(db execer, indexer string, height uint64) error {
+	_, err := db.Exec(fmt.Sprintf("INSERT INTO %s (indexer, height, updated) VALUES ($1, $2, NOW()) "+
+		"ON CONFLICT (indexer) DO UPDATE SET height = EXCLUDED.height, updated = EXCLUDED.updated", checkpointsTable),
+		indexer, height)
+	if err != nil {
+		return errors.New(fmt.Sprintf("set checkpoint of %s err: %v", indexer, err))
+	}
+	return nil
+}
+
+// Commit stores the records, keyed by table name, the opening positions of
+// the accounts first recorded and the processed blocks of indexer and moves
+// its checkpoint to height, all in one transaction.
+func (p *PostgresDB) Commit(indexer string, height uint64, records map[string][]*types.TokenRecord, positions []*types.Position, blocks []*types.BlockRef) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return errors.New(fmt.Sprintf("db begin err: %v", err))
+	}
+	defer tx.Rollback()
+
+	tables := make([]string, 0, len(records))
+	for table := range records {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		if err := p.createRecordTable(tx, table); err != nil {
+			return err
+		}
+		if err := insertRecords(tx, table, records[table]); err != nil {
+			return err
+		}
+	}
+	if len(positions) > 0 {
+		if err := insertOpeningPositions(tx, positions); err != nil {
+			return err
+		}
+	}
+	if len(blocks) > 0 {
+		if err := insertBlocks(tx, indexer, blocks); err != nil {
+			return err
+		}
+	}
+	if err := setCheckpoint(tx, indexer, height); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New(fmt.Sprintf("db tx commit err: %v", err))
+	}
+	for _, table := range tables {
+		p.knownTables[table] = true
+	}
+	return nil
+}
+
+// Rollback removes the records above block number from the daily record
+// tables named prefix+YYYYMMDD of day sinceDate and later, together with the
+// recorded blocks of indexer above number, and moves its checkpoint back to
+// number, all in one transaction.
+func (p *PostgresDB) Rollback(indexer, prefix, sinceDate string, number uint64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return errors.New(fmt.Sprintf("db begin err: %v", err))
+	}
+	defer tx.Rollback()
+
+	if err := deleteRecords(tx, prefix, sinceDate, number); err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE indexer = $1 AND number > $2", blocksTable), indexer, number)
+	if err != nil {
+		return errors.New(fmt.Sprintf("delete blocks of %s err: %v", indexer, err))
+	}
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET height = $2, updated = NOW() WHERE indexer = $1 AND height > $2", checkpointsTable),
+		indexer, number)
+	if err != nil {
+		return errors.New(fmt.Sprintf("roll back checkpoint of %s err: %v", indexer, err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New(fmt.Sprintf("db tx commit err: %v", err))
+	}
+	return nil
+}
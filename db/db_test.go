@@ -0,0 +1,144 @@
+package db_test
+
+import (
+	"database/sql"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/db/dbtest"
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+var (
+	alice = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	bob   = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+	cUSD  = common.HexToAddress("0x765DE816845861e75A25fCA122bb6898B8B1282a")
+)
+
+// open returns a database on the stand-in together with a plain connection
+// to it.
+func open(t *testing.T) (*db.PostgresDB, *sql.DB) {
+	host := dbtest.Use(t)
+	database, err := db.NewDB("creda", "", "", host, 5432)
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	conn, err := sql.Open(db.Driver, "dbname=creda host="+host)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, database.CreateBlockTable())
+	require.NoError(t, database.CreateCheckpointTable())
+	return database, conn
+}
+
+func record(number uint64, logIndex int, value int64) *types.TokenRecord {
+	return &types.TokenRecord{
+		CoinID:      7236,
+		BlockNumber: number,
+		Timestamp:   1588334400 + number,
+		TxHash:      common.BigToHash(big.NewInt(int64(number))),
+		From:        alice,
+		To:          bob,
+		Value:       big.NewInt(value),
+		LogIndex:    logIndex,
+	}
+}
+
+func block(number uint64) *types.BlockRef {
+	return &types.BlockRef{
+		Number:     number,
+		Hash:       common.BigToHash(big.NewInt(int64(number) + 100)),
+		ParentHash: common.BigToHash(big.NewInt(int64(number) + 99)),
+	}
+}
+
+// TestCommitRollback tests that committing a range again replaces its
+// records, and that a rollback keeps the records, blocks and checkpoint up
+// to the ancestor.
+func TestCommitRollback(t *testing.T) {
+	database, _ := open(t)
+	day := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	records := map[string][]*types.TokenRecord{"event20200501": {record(1, 0, 5), record(2, 0, 6), record(3, 1, 7)}}
+	blocks := []*types.BlockRef{block(1), block(2), block(3)}
+	require.NoError(t, database.Commit("token", 3, records, nil, blocks))
+	records["event20200501"][2].Value = big.NewInt(8)
+	require.NoError(t, database.Commit("token", 3, records, nil, blocks))
+
+	stored, err := database.ReadTokenTransferHistory(day)
+	require.NoError(t, err)
+	require.Len(t, stored, 3)
+	require.Equal(t, "8", stored[2].Value.String())
+	height, ok, err := database.GetCheckpoint("token")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(3), height)
+
+	require.NoError(t, database.Rollback("token", "event", "20200501", 1))
+	stored, err = database.ReadTokenTransferHistory(day)
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	require.Equal(t, uint64(1), stored[0].BlockNumber)
+	height, _, err = database.GetCheckpoint("token")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), height)
+	hash, ok, err := database.GetBlockHash("token", 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, block(1).Hash, hash)
+	_, ok, err = database.GetBlockHash("token", 2)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+// TestRecordTableMigration tests that record tables of earlier versions get
+// the key and frame type columns.
+func TestRecordTableMigration(t *testing.T) {
+	database, conn := open(t)
+	_, err := conn.Exec("CREATE TABLE tx_20200501 (id SERIAL PRIMARY KEY, coinID INT, blocknumber INT, timestamp INT, " +
+		"txhash VARCHAR(66), fromAddress VARCHAR(42), toAddress VARCHAR(42), value TEXT)")
+	require.NoError(t, err)
+	_, err = conn.Exec("INSERT INTO tx_20200501 (coinID, blocknumber, timestamp, txhash, fromAddress, toAddress, value) "+
+		"VALUES (5567, 1, 1588334401, '0x01', $1, $2, '5')", alice.String(), bob.String())
+	require.NoError(t, err)
+
+	require.NoError(t, database.CreateRecordTable("tx_20200501"))
+	r := record(2, -1, 6)
+	r.TraceAddress, r.FrameType = "0", "CALL"
+	require.NoError(t, database.InsertRecords("tx_20200501", []*types.TokenRecord{r, r}))
+
+	stored, err := database.ReadPullTxHistory(time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	require.Equal(t, -1, stored[0].LogIndex)
+	require.Equal(t, "CALL", stored[1].FrameType)
+
+	addresses, err := database.ReadAddresses("tx_")
+	require.NoError(t, err)
+	require.Equal(t, map[common.Address]bool{alice: true, bob: true}, addresses[7236])
+}
+
+// TestUpserts tests that tokens and snapshots are replaced under their key.
+func TestUpserts(t *testing.T) {
+	database, conn := open(t)
+	require.NoError(t, database.CreateTokenTable())
+	require.NoError(t, database.UpsertTokens([]*types.Token{{Address: cUSD, Name: "cUSD", CoinID: 7236, Decimals: 18}}))
+	require.NoError(t, database.UpsertTokens([]*types.Token{{Address: cUSD, Name: "cUSD", Symbol: "cUSD", CoinID: 7236, Decimals: 18, StartBlock: 5}}))
+	tokens, err := database.ReadTokens()
+	require.NoError(t, err)
+	require.Equal(t, []*types.Token{{Address: cUSD, Name: "cUSD", Symbol: "cUSD", CoinID: 7236, Decimals: 18, StartBlock: 5}}, tokens)
+
+	require.NoError(t, database.CreateSnapshotTable())
+	balance := &types.Balance{BlockNumber: 9, Address: alice, Token: cUSD, CoinID: 7236, Value: big.NewInt(1)}
+	require.NoError(t, database.InsertSnapshot([]*types.Balance{balance}))
+	balance.Value = big.NewInt(2)
+	require.NoError(t, database.InsertSnapshot([]*types.Balance{balance}))
+	var count int
+	var value string
+	require.NoError(t, conn.QueryRow("SELECT COUNT(*), MAX(value) FROM balance_snapshots").Scan(&count, &value))
+	require.Equal(t, 1, count)
+	require.Equal(t, "2", value)
+}
@@ -0,0 +1,125 @@
+// Package dbtest provides a stand-in for the Postgres server PostgresDB talks
+// to, so that the storage layer and the whole pipeline run in tests without
+// one. It registers the database/sql driver "pgtest", which keeps every
+// database in a SQLite file and rewrites the Postgres dialect of the
+// statements of package db into SQLite.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"modernc.org/sqlite"
+)
+
+// DriverName is the name the stand-in is registered under.
+const DriverName = "pgtest"
+
+func init() {
+	sql.Register(DriverName, &pgDriver{})
+}
+
+// Use makes PostgresDB connect to the stand-in until the test ends and
+// returns the host to configure, a directory holding the databases.
+func Use(t testing.TB) string {
+	driver := db.Driver
+	db.Driver = DriverName
+	t.Cleanup(func() { db.Driver = driver })
+	return t.TempDir()
+}
+
+// rewrites translate the Postgres dialect used by package db into SQLite.
+var rewrites = []struct {
+	pattern *regexp.Regexp
+	replace string
+}{
+	{regexp.MustCompile(`\$(\d+)`), `?$1`},
+	{regexp.MustCompile(`(?i)\bNOW\(\)`), `CURRENT_TIMESTAMP`},
+	{regexp.MustCompile(`(?i)\bSERIAL PRIMARY KEY`), `INTEGER PRIMARY KEY AUTOINCREMENT`},
+	{regexp.MustCompile(`(?i)\bFROM information_schema\.tables WHERE table_schema\s*=\s*'public' AND`),
+		`FROM (SELECT name AS table_name FROM sqlite_master WHERE type = 'table') WHERE`},
+	// Postgres escapes LIKE patterns with a backslash by default.
+	{regexp.MustCompile(`(?i)\bLIKE (\?\d+)`), `LIKE $1 ESCAPE '\'`},
+	// Databases are files, created when they are opened.
+	{regexp.MustCompile(`(?is)^SELECT EXISTS \(SELECT FROM pg_database\b.*\)$`), `SELECT 1`},
+	{regexp.MustCompile(`(?is)^(CREATE|DROP) DATABASE\b.*$`), `SELECT 1`},
+}
+
+// addColumn matches the migrations adding a column unless it exists, which
+// SQLite does not support.
+var addColumn = regexp.MustCompile(`(?is)^ALTER TABLE (\w+) ADD COLUMN IF NOT EXISTS (\w+)(.*)$`)
+
+type pgDriver struct{}
+
+// Open opens the database named by the dbname of the Postgres connection
+// string dsn in the directory given as its host.
+func (pgDriver) Open(dsn string) (driver.Conn, error) {
+	params := make(map[string]string)
+	for _, field := range strings.Fields(dsn) {
+		if key, value, ok := strings.Cut(field, "="); ok {
+			params[key] = value
+		}
+	}
+	name := params["dbname"]
+	if name == "" {
+		name = "postgres"
+	}
+	path := filepath.Join(params["host"], name+".db")
+	c, err := (&sqlite.Driver{}).Open("file:" + path +
+		"?_pragma=busy_timeout(10000)&_pragma=journal_mode(wal)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c}, nil
+}
+
+// conn rewrites the statements it prepares. database/sql runs every Exec and
+// Query through Prepare, as conn hides the other interfaces of the SQLite
+// connection.
+type conn struct {
+	driver.Conn
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	query, err := c.rewrite(strings.TrimSpace(query))
+	if err != nil {
+		return nil, err
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *conn) rewrite(query string) (string, error) {
+	query = strings.TrimSuffix(query, ";")
+	for _, r := range rewrites {
+		query = r.pattern.ReplaceAllString(query, r.replace)
+	}
+	if m := addColumn.FindStringSubmatch(query); m != nil {
+		exists, err := c.hasColumn(m[1], m[2])
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return "SELECT 1", nil
+		}
+		return "ALTER TABLE " + m[1] + " ADD COLUMN " + m[2] + m[3], nil
+	}
+	return query, nil
+}
+
+// hasColumn reports whether table has column.
+func (c *conn) hasColumn(table, column string) (bool, error) {
+	rows, err := c.Conn.(driver.QueryerContext).QueryContext(context.Background(),
+		"SELECT name FROM pragma_table_info(?1) WHERE name = ?2 COLLATE NOCASE",
+		[]driver.NamedValue{{Ordinal: 1, Value: table}, {Ordinal: 2, Value: column}})
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(make([]driver.Value, 1)) == nil, nil
+}
@@ -1,23 +0,0 @@
-package db
-
-import "github.com/xuxinlai2002/creda-celo-balance/types"
-
-var TokenDecimals = map[types.COINID]int{
-	825:   6,
-	3408:  6,
-	20650: 6,
-	3717:  18,
-	5567:  18,
-	6758:  18,
-	10238: 18,
-	9467:  18,
-	8442:  18,
-	7236:  18,
-	17334: 18,
-	15575: 9,
-	9588:  18,
-	12212: 18,
-	10808: 18,
-	13021: 18,
-	16385: 18,
-}
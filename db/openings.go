@@ -0,0 +1,216 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+// ReadAddresses returns the accounts sending or receiving a coin in the
+// daily record tables named prefix+YYYYMMDD, by coin id. The zero address,
+// standing for mints and burns, is left out.
+func (p *PostgresDB) ReadAddresses(prefix string) (map[types.COINID]map[common.Address]bool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	tables, err := recordTables(p.db, prefix, "")
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("list %s tables err: %v", prefix, err))
+	}
+	addresses := make(map[types.COINID]map[common.Address]bool)
+	for _, table := range tables {
+		rows, err := p.db.Query(fmt.Sprintf("SELECT coinid, fromaddress FROM %s UNION SELECT coinid, toaddress FROM %s", table, table))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("read addresses of %s err: %v", table, err))
+		}
+		for rows.Next() {
+			var coinID uint64
+			var address string
+			if err := rows.Scan(&coinID, &address); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			account := common.HexToAddress(address)
+			if account == common.ZeroAddress {
+				continue
+			}
+			if addresses[types.COINID(coinID)] == nil {
+				addresses[types.COINID(coinID)] = make(map[common.Address]bool)
+			}
+			addresses[types.COINID(coinID)][account] = true
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return addresses, nil
+}
+
+const (
+	// openingsTable holds the balances accounts held before the indexers
+	// started, one per account and token.
+	openingsTable = "opening_balances"
+	// openingPositionsTable holds the LockedGold positions accounts held
+	// before the tx indexer started, one per account.
+	openingPositionsTable = "opening_positions"
+)
+
+// CreateOpeningTable creates the tables of opening balances and positions.
+func (p *PostgresDB) CreateOpeningTable() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, createTableSQL := range []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+			"blocknumber BIGINT,"+
+			"address VARCHAR(42),"+
+			"token VARCHAR(42),"+
+			"coinid INT,"+
+			"value TEXT,"+
+			"PRIMARY KEY (address, token)"+
+			");", openingsTable),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+			"blocknumber BIGINT,"+
+			"address VARCHAR(42) PRIMARY KEY,"+
+			"locked TEXT,"+
+			"pending TEXT"+
+			");", openingPositionsTable),
+	} {
+		if _, err := p.db.Exec(createTableSQL); err != nil {
+			return errors.New(fmt.Sprintf("create opening tables err: %v", err))
+		}
+	}
+	return nil
+}
+
+// InsertOpenings stores opening balances. An account keeps the first opening
+// balance stored for a token, so indexers seeding the same account
+// concurrently do not conflict.
+func (p *PostgresDB) InsertOpenings(balances []*types.Balance) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return errors.New(fmt.Sprintf("db begin err: %v", err))
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (blocknumber, address, token, coinid, value) VALUES ($1, $2, $3, $4, $5) "+
+		"ON CONFLICT (address, token) DO NOTHING", openingsTable))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, b := range balances {
+		if _, err := stmt.Exec(b.BlockNumber, b.Address.String(), b.Token.String(), b.CoinID, b.Value.String()); err != nil {
+			return errors.New(fmt.Sprintf("insert opening of %v err: %v", b.Address, err))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New(fmt.Sprintf("db tx commit err: %v", err))
+	}
+	return nil
+}
+
+// ReadOpenings returns every opening balance.
+func (p *PostgresDB) ReadOpenings() ([]*types.Balance, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	rows, err := p.db.Query(fmt.Sprintf("SELECT blocknumber, address, token, coinid, value FROM %s", openingsTable))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("read %s err: %v", openingsTable, err))
+	}
+	defer rows.Close()
+
+	balances := make([]*types.Balance, 0)
+	for rows.Next() {
+		var address, token, value string
+		b := &types.Balance{}
+		if err := rows.Scan(&b.BlockNumber, &address, &token, &b.CoinID, &value); err != nil {
+			return nil, err
+		}
+		v, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("opening of %s is not a number: %s", address, value))
+		}
+		b.Address, b.Token, b.Value = common.HexToAddress(address), common.HexToAddress(token), v
+		balances = append(balances, b)
+	}
+	return balances, rows.Err()
+}
+
+// InsertOpeningPositions stores opening LockedGold positions, keeping the
+// first one stored for an account like InsertOpenings.
+func (p *PostgresDB) InsertOpeningPositions(positions []*types.Position) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return errors.New(fmt.Sprintf("db begin err: %v", err))
+	}
+	defer tx.Rollback()
+
+	if err := insertOpeningPositions(tx, positions); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.New(fmt.Sprintf("db tx commit err: %v", err))
+	}
+	return nil
+}
+
+func insertOpeningPositions(tx *sql.Tx, positions []*types.Position) error {
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (blocknumber, address, locked, pending) VALUES ($1, $2, $3, $4) "+
+		"ON CONFLICT (address) DO NOTHING", openingPositionsTable))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, position := range positions {
+		if _, err := stmt.Exec(position.BlockNumber, position.Address.String(), position.Locked.String(), position.Pending.String()); err != nil {
+			return errors.New(fmt.Sprintf("insert opening position of %v err: %v", position.Address, err))
+		}
+	}
+	return nil
+}
+
+// ReadOpeningPositions returns every opening LockedGold position.
+func (p *PostgresDB) ReadOpeningPositions() ([]*types.Position, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	rows, err := p.db.Query(fmt.Sprintf("SELECT blocknumber, address, locked, pending FROM %s", openingPositionsTable))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("read %s err: %v", openingPositionsTable, err))
+	}
+	defer rows.Close()
+
+	positions := make([]*types.Position, 0)
+	for rows.Next() {
+		var address, locked, pending string
+		position := &types.Position{}
+		if err := rows.Scan(&position.BlockNumber, &address, &locked, &pending); err != nil {
+			return nil, err
+		}
+		var ok bool
+		if position.Locked, ok = new(big.Int).SetString(locked, 10); !ok {
+			return nil, errors.New(fmt.Sprintf("opening position of %s is not a number: %s", address, locked))
+		}
+		if position.Pending, ok = new(big.Int).SetString(pending, 10); !ok {
+			return nil, errors.New(fmt.Sprintf("opening position of %s is not a number: %s", address, pending))
+		}
+		position.Address = common.HexToAddress(address)
+		positions = append(positions, position)
+	}
+	return positions, rows.Err()
+}
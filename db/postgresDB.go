@@ -16,13 +16,20 @@ import (
 	"github.com/xuxinlai2002/creda-celo-balance/types"
 )
 
+// Driver is the database/sql driver PostgresDB connects with. Tests switch it
+// to the stand-in of package dbtest.
+var Driver = "postgres"
+
 type PostgresDB struct {
 	db   *sql.DB
 	lock sync.Mutex
+
+	// knownTables caches the record tables known to exist.
+	knownTables map[string]bool
 }
 
 func CreateDataBase(dbName, user, password, host string, port uint32) error {
-	db, err := sql.Open("postgres", fmt.Sprintf("user=%s  sslmode=disable password=%s host=%s port=%d", user, password, host, port))
+	db, err := sql.Open(Driver, fmt.Sprintf("user=%s  sslmode=disable password=%s host=%s port=%d", user, password, host, port))
 	if err != nil {
 		fmt.Println("failed open databases", err)
 		return err
@@ -50,14 +57,15 @@ func CreateDataBase(dbName, user, password, host string, port uint32) error {
 }
 
 func NewDB(dbName, user, password, host string, port uint32) (*PostgresDB, error) {
-	db, err := sql.Open("postgres", fmt.Sprintf("user=%s dbname=%s sslmode=disable password=%s host=%s port=%d", user, dbName, password, host, port))
+	db, err := sql.Open(Driver, fmt.Sprintf("user=%s dbname=%s sslmode=disable password=%s host=%s port=%d", user, dbName, password, host, port))
 	if err != nil {
 		fmt.Println("failed open databases", err)
 		return nil, err
 	}
 
 	self := &PostgresDB{
-		db: db,
+		db:          db,
+		knownTables: make(map[string]bool),
 	}
 	return self, nil
 }
@@ -66,10 +74,35 @@ func (p *PostgresDB) Close() error {
 	return p.db.Close()
 }
 
+// execer is implemented by *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// CreateRecordTable creates the transfer table tableName. A transfer is keyed
+// by transaction, log index or trace address and coin, so that re-inserting
+// a block range replaces its transfers instead of duplicating them.
 func (p *PostgresDB) CreateRecordTable(tableName string) error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
+	if err := p.createRecordTable(p.db, tableName); err != nil {
+		return err
+	}
+	p.knownTables[tableName] = true
+	return nil
+}
 
+// createRecordTable creates tableName unless it is known to exist. Callers
+// mark the table known once the creation is committed.
+func (p *PostgresDB) createRecordTable(db execer, tableName string) error {
+	if p.knownTables[tableName] {
+		return nil
+	}
 	createTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
 		"id SERIAL PRIMARY KEY,"+
 		"coinID INT,"+
@@ -78,16 +111,38 @@ func (p *PostgresDB) CreateRecordTable(tableName string) error {
 		"txhash VARCHAR(66),"+
 		"fromAddress VARCHAR(42),"+
 		"toAddress VARCHAR(42),"+
-		"value TEXT"+
+		"value TEXT,"+
+		"txindex INT NOT NULL DEFAULT 0,"+
+		"logindex INT NOT NULL DEFAULT -1,"+
+		"traceaddress TEXT NOT NULL DEFAULT '',"+
+		"frametype VARCHAR(16) NOT NULL DEFAULT ''"+
 		");", tableName)
-	_, err := p.db.Exec(createTableSQL)
+	_, err := db.Exec(createTableSQL)
 	if err != nil {
 		return errors.New(fmt.Sprintf("create sql table %s err: %v", tableName, err))
 	}
 
+	// Tables created by earlier versions lack the key and frame type columns.
+	for _, column := range []string{
+		"txindex INT NOT NULL DEFAULT 0",
+		"logindex INT NOT NULL DEFAULT -1",
+		"traceaddress TEXT NOT NULL DEFAULT ''",
+		"frametype VARCHAR(16) NOT NULL DEFAULT ''",
+	} {
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s", tableName, column)); err != nil {
+			return errors.New(fmt.Sprintf("alter sql table %s err: %v", tableName, err))
+		}
+	}
+	_, err = db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s_transfer_key ON %s (txhash, logindex, traceaddress, coinID)", tableName, tableName))
+	if err != nil {
+		return errors.New(fmt.Sprintf("create transfer key of %s err: %v, the table may hold transfers "+
+			"indexed by an earlier version and has to be dropped and indexed again", tableName, err))
+	}
 	return nil
 }
 
+// InsertRecords inserts records into tableName, replacing transfers already
+// stored under the same key.
 func (p *PostgresDB) InsertRecords(tableName string, records []*types.TokenRecord) error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
@@ -98,26 +153,8 @@ func (p *PostgresDB) InsertRecords(tableName string, records []*types.TokenRecor
 	}
 	defer tx.Rollback()
 
-	for _, record := range records {
-		sqlInsert := fmt.Sprintf("INSERT INTO %s ("+
-			"coinID,"+
-			"blocknumber,"+
-			"timestamp,"+
-			"txhash,"+
-			"fromAddress,"+
-			"toAddress,"+
-			"value"+
-			") VALUES ($1,$2,$3,$4,$5,$6,$7)", tableName)
-		stmt, err := tx.Prepare(sqlInsert)
-		if err != nil {
-			return errors.New(fmt.Sprintf("db prepare err: %v", err))
-		}
-		defer stmt.Close()
-
-		_, err = stmt.Exec(record.CoinID, record.BlockNumber, record.Timestamp, record.TxHash.String(), record.From.String(), record.To.String(), record.Value.String())
-		if err != nil {
-			return errors.New(fmt.Sprintf("db stmt exec err: %v", err))
-		}
+	if err := insertRecords(tx, tableName, records); err != nil {
+		return err
 	}
 
 	// 提交事务
@@ -128,6 +165,44 @@ func (p *PostgresDB) InsertRecords(tableName string, records []*types.TokenRecor
 	return nil
 }
 
+func insertRecords(tx *sql.Tx, tableName string, records []*types.TokenRecord) error {
+	sqlInsert := fmt.Sprintf("INSERT INTO %s ("+
+		"coinID,"+
+		"blocknumber,"+
+		"timestamp,"+
+		"txhash,"+
+		"fromAddress,"+
+		"toAddress,"+
+		"value,"+
+		"txindex,"+
+		"logindex,"+
+		"traceaddress,"+
+		"frametype"+
+		") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) "+
+		"ON CONFLICT (txhash, logindex, traceaddress, coinID) DO UPDATE SET "+
+		"blocknumber = EXCLUDED.blocknumber,"+
+		"timestamp = EXCLUDED.timestamp,"+
+		"fromAddress = EXCLUDED.fromAddress,"+
+		"toAddress = EXCLUDED.toAddress,"+
+		"value = EXCLUDED.value,"+
+		"txindex = EXCLUDED.txindex,"+
+		"frametype = EXCLUDED.frametype", tableName)
+	stmt, err := tx.Prepare(sqlInsert)
+	if err != nil {
+		return errors.New(fmt.Sprintf("db prepare err: %v", err))
+	}
+	defer stmt.Close()
+
+	for _, record := range records {
+		_, err = stmt.Exec(record.CoinID, record.BlockNumber, record.Timestamp, record.TxHash.String(), record.From.String(), record.To.String(), record.Value.String(),
+			record.TxIndex, record.LogIndex, record.TraceAddress, record.FrameType)
+		if err != nil {
+			return errors.New(fmt.Sprintf("db stmt exec err: %v", err))
+		}
+	}
+	return nil
+}
+
 func (p *PostgresDB) ReadPullTxHistory(t time.Time) ([]*types.TokenRecord, error) {
 	tableName := p.getPullTxTableNameByDate(t)
 	return p.queryTable(tableName)
@@ -141,7 +216,7 @@ func (p *PostgresDB) ReadTokenTransferHistory(t time.Time) ([]*types.TokenRecord
 func (p *PostgresDB) queryTable(tableName string) ([]*types.TokenRecord, error) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
-	query := fmt.Sprintf("SELECT coinid, blocknumber, timestamp, txhash, fromaddress, toaddress, value FROM %s", tableName)
+	query := fmt.Sprintf("SELECT coinid, blocknumber, timestamp, txhash, fromaddress, toaddress, value, txindex, logindex, traceaddress, frametype FROM %s", tableName)
 	rows, err := p.db.Query(query)
 	if err != nil {
 		return nil, err
@@ -150,8 +225,10 @@ func (p *PostgresDB) queryTable(tableName string) ([]*types.TokenRecord, error)
 
 	records := make([]*types.TokenRecord, 0)
 	for rows.Next() {
-		var coinID, blocknumber, timestamp, txhash, fromAddress, toAddress, value string
-		if err := rows.Scan(&coinID, &blocknumber, &timestamp, &txhash, &fromAddress, &toAddress, &value); err != nil {
+		var coinID, blocknumber, timestamp, txhash, fromAddress, toAddress, value, traceAddress, frameType string
+		var txIndex uint
+		var logIndex int
+		if err := rows.Scan(&coinID, &blocknumber, &timestamp, &txhash, &fromAddress, &toAddress, &value, &txIndex, &logIndex, &traceAddress, &frameType); err != nil {
 			return nil, err
 		}
 		coinid, ok := big.NewInt(0).SetString(coinID, 10)
@@ -175,13 +252,17 @@ func (p *PostgresDB) queryTable(tableName string) ([]*types.TokenRecord, error)
 		}
 
 		record := &types.TokenRecord{
-			CoinID:      coinid.Uint64(),
-			BlockNumber: number.Uint64(),
-			Timestamp:   time.Uint64(),
-			TxHash:      txID,
-			From:        from,
-			To:          to,
-			Value:       amount,
+			CoinID:       coinid.Uint64(),
+			BlockNumber:  number.Uint64(),
+			Timestamp:    time.Uint64(),
+			TxHash:       txID,
+			TxIndex:      txIndex,
+			From:         from,
+			To:           to,
+			Value:        amount,
+			LogIndex:     logIndex,
+			TraceAddress: traceAddress,
+			FrameType:    frameType,
 		}
 		records = append(records, record)
 	}
@@ -218,16 +299,32 @@ func (p *PostgresDB) CreateBalanceTable(tableName string) error {
 		"id SERIAL PRIMARY KEY,"+
 		"date DATE,"+
 		"address VARCHAR(42),"+
-		"value TEXT"+
+		"value TEXT,"+
+		"locked TEXT NOT NULL DEFAULT '0',"+
+		"pendingwithdrawal TEXT NOT NULL DEFAULT '0'"+
 		");", tableName)
 	_, err := p.db.Exec(createTableSQL)
 	if err != nil {
 		return errors.New(fmt.Sprintf("create balance table %s err: %v", tableName, err))
 	}
+
+	// Tables created by earlier versions lack the LockedGold columns.
+	for _, column := range []string{
+		"locked TEXT NOT NULL DEFAULT '0'",
+		"pendingwithdrawal TEXT NOT NULL DEFAULT '0'",
+	} {
+		if _, err := p.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s", tableName, column)); err != nil {
+			return errors.New(fmt.Sprintf("alter balance table %s err: %v", tableName, err))
+		}
+	}
 	return nil
 }
 
-func (p *PostgresDB) InsertAccountHistoryBalance(tableName string, dateStr types.DATE, history map[types.ADDRESS]map[types.COINID]*big.Int, cmcHistory map[types.COINID]map[types.DATE]*big.Float) error {
+// InsertAccountHistoryBalance values the balances of every account on dateStr
+// in USD. The value column is the net worth of the account: its liquid
+// balances plus its LockedGold positions, which are also valued on their own
+// in the locked and pendingwithdrawal columns.
+func (p *PostgresDB) InsertAccountHistoryBalance(tableName string, dateStr types.DATE, history map[types.ADDRESS]map[types.COINID]*big.Int, positions map[types.ADDRESS]*types.LockedGold, cmcHistory map[types.COINID]map[types.DATE]*big.Float, decimals map[types.COINID]uint8) error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 	tx, err := p.db.Begin()
@@ -235,38 +332,67 @@ func (p *PostgresDB) InsertAccountHistoryBalance(tableName string, dateStr types
 		return err
 	}
 	defer tx.Rollback()
-	for address, coinBalances := range history {
+	stmt, err := tx.Prepare("INSERT INTO " + tableName + "(date, address, value, locked, pendingwithdrawal) VALUES($1, $2, $3, $4, $5)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	price := func(coinID types.COINID) *big.Float {
+		if cmcHistory[coinID] != nil && cmcHistory[coinID][dateStr] != nil {
+			return cmcHistory[coinID][dateStr]
+		}
+		return big.NewFloat(0)
+	}
+	// value returns balance with decimal * price
+	value := func(address types.ADDRESS, coinID types.COINID, balance *big.Int) (*big.Float, error) {
+		if balance.Sign() < 0 {
+			str := fmt.Sprintf("balance is positive address:%s, date:%s, coinID:%v", address, dateStr, coinID)
+			panic(any(str))
+		}
+		decimal, ok := decimals[coinID]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("unknown decimals of coin %v", coinID))
+		}
+		balanceWithPrice := new(big.Float).SetInt(balance)
+		balanceWithPrice.Quo(balanceWithPrice, new(big.Float).SetFloat64(math.Pow(float64(10), float64(decimal))))
+		return balanceWithPrice.Mul(balanceWithPrice, price(coinID)), nil
+	}
+
+	addresses := make(map[types.ADDRESS]bool, len(history))
+	for address := range history {
+		addresses[address] = true
+	}
+	for address := range positions {
+		addresses[address] = true
+	}
+	for address := range addresses {
 		balanceF := new(big.Float)
-		for coinID, balance := range coinBalances {
-			price := big.NewFloat(0)
-			if cmcHistory[coinID] != nil && cmcHistory[coinID][dateStr] != nil {
-				price = cmcHistory[coinID][dateStr]
-			}
-			// change balance to positive
-			if balance.Sign() < 0 {
-				str := fmt.Sprintf("balance is positive address:%s, date:%s, coinID:%v", address, dateStr, coinID)
-				panic(any(str))
+		for coinID, balance := range history[address] {
+			balanceWithPrice, err := value(address, coinID, balance)
+			if err != nil {
+				return err
 			}
-			decimal := TokenDecimals[coinID]
-			// balance with decimal * price
-			balanceWithPrice := new(big.Float).SetInt(balance)
-			balanceWithPrice.Quo(balanceWithPrice, new(big.Float).SetFloat64(math.Pow(float64(10), float64(decimal))))
-			balanceWithPrice.Mul(balanceWithPrice, price)
-
 			balanceF.Add(balanceF, balanceWithPrice)
 		}
+		locked, pending := new(big.Float), new(big.Float)
+		if position := positions[address]; position != nil {
+			if locked, err = value(address, types.CELO_COINID, position.Locked); err != nil {
+				return err
+			}
+			if pending, err = value(address, types.CELO_COINID, position.Pending); err != nil {
+				return err
+			}
+		}
+		balanceF.Add(balanceF, locked)
+		balanceF.Add(balanceF, pending)
 		// if balanceF equal 0, then continue
 		if balanceF.Cmp(big.NewFloat(0)) == 0 {
 			continue
 		}
 
-		stmt, err := tx.Prepare("INSERT INTO " + tableName + "(date, address, value) VALUES($1, $2, $3)")
-		if err != nil {
-			return err
-		}
-		defer stmt.Close()
 		fmt.Println("insert into " + tableName + " " + string(dateStr) + " " + string(address) + " " + balanceF.Text('f', 18))
-		_, err = stmt.Exec(dateStr, address, balanceF.Text('f', 18))
+		_, err = stmt.Exec(dateStr, address, balanceF.Text('f', 18), locked.Text('f', 18), pending.Text('f', 18))
 		if err != nil {
 			return err
 		}
@@ -278,3 +404,113 @@ func (p *PostgresDB) InsertAccountHistoryBalance(tableName string, dateStr types
 	}
 	return nil
 }
+
+// CreateCoinBalanceTable creates the table holding the liquid balance of
+// every account in every coin on one day, in the units of the coin.
+func (p *PostgresDB) CreateCoinBalanceTable(tableName string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	createTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+		"date DATE,"+
+		"address VARCHAR(42),"+
+		"coinid INT,"+
+		"value TEXT,"+
+		"PRIMARY KEY (address, coinid)"+
+		");", tableName)
+	_, err := p.db.Exec(createTableSQL)
+	if err != nil {
+		return errors.New(fmt.Sprintf("create coin balance table %s err: %v", tableName, err))
+	}
+	return nil
+}
+
+// InsertAccountCoinBalance stores the non-zero balances of history, replacing
+// those stored by an earlier run.
+func (p *PostgresDB) InsertAccountCoinBalance(tableName string, dateStr types.DATE, history map[types.ADDRESS]map[types.COINID]*big.Int) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM " + tableName); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO " + tableName + "(date, address, coinid, value) VALUES($1, $2, $3, $4)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for address, coinBalances := range history {
+		for coinID, balance := range coinBalances {
+			if balance.Sign() == 0 {
+				continue
+			}
+			if _, err := stmt.Exec(dateStr, address, uint64(coinID), balance.String()); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// ReadBalanceAddresses returns the accounts valued in the balance table
+// tableName.
+func (p *PostgresDB) ReadBalanceAddresses(tableName string) ([]common.Address, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	rows, err := p.db.Query("SELECT DISTINCT address FROM " + tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := make([]common.Address, 0)
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, common.HexToAddress(address))
+	}
+	return addresses, rows.Err()
+}
+
+// ReadCoinBalances returns the balances stored in the coin balance table
+// tableName for addresses, by account and coin id.
+func (p *PostgresDB) ReadCoinBalances(tableName string, addresses []common.Address) (map[common.Address]map[types.COINID]*big.Int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	wanted := make(map[common.Address]bool, len(addresses))
+	for _, address := range addresses {
+		wanted[address] = true
+	}
+	rows, err := p.db.Query("SELECT address, coinid, value FROM " + tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := make(map[common.Address]map[types.COINID]*big.Int)
+	for rows.Next() {
+		var address, value string
+		var coinID uint64
+		if err := rows.Scan(&address, &coinID, &value); err != nil {
+			return nil, err
+		}
+		account := common.HexToAddress(address)
+		if !wanted[account] {
+			continue
+		}
+		balance, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("value is error%s", value))
+		}
+		if balances[account] == nil {
+			balances[account] = make(map[types.COINID]*big.Int)
+		}
+		balances[account][types.COINID(coinID)] = balance
+	}
+	return balances, rows.Err()
+}
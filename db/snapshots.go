@@ -0,0 +1,61 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+const snapshotsTable = "balance_snapshots"
+
+// CreateSnapshotTable creates the table holding balances read from the chain
+// at a block, keyed by block, account and token.
+func (p *PostgresDB) CreateSnapshotTable() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	createTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+		"blocknumber BIGINT,"+
+		"address VARCHAR(42),"+
+		"token VARCHAR(42),"+
+		"coinid INT,"+
+		"value TEXT,"+
+		"PRIMARY KEY (blocknumber, address, token)"+
+		");", snapshotsTable)
+	_, err := p.db.Exec(createTableSQL)
+	if err != nil {
+		return errors.New(fmt.Sprintf("create sql table %s err: %v", snapshotsTable, err))
+	}
+	return nil
+}
+
+// InsertSnapshot stores balances, replacing those already stored for the same
+// block, account and token.
+func (p *PostgresDB) InsertSnapshot(balances []*types.Balance) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return errors.New(fmt.Sprintf("db begin err: %v", err))
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (blocknumber, address, token, coinid, value) VALUES ($1, $2, $3, $4, $5) "+
+		"ON CONFLICT (blocknumber, address, token) DO UPDATE SET coinid = EXCLUDED.coinid, value = EXCLUDED.value", snapshotsTable))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, b := range balances {
+		if _, err := stmt.Exec(b.BlockNumber, b.Address.String(), b.Token.String(), b.CoinID, b.Value.String()); err != nil {
+			return errors.New(fmt.Sprintf("insert snapshot of %v err: %v", b.Address, err))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New(fmt.Sprintf("db tx commit err: %v", err))
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+const tokensTable = "tokens"
+
+// CreateTokenTable creates the table holding the metadata of the tracked
+// tokens.
+func (p *PostgresDB) CreateTokenTable() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	createTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+		"address VARCHAR(42) PRIMARY KEY,"+
+		"name VARCHAR(64),"+
+		"symbol VARCHAR(64),"+
+		"coinid BIGINT,"+
+		"decimals SMALLINT,"+
+		"startblock BIGINT"+
+		");", tokensTable)
+	_, err := p.db.Exec(createTableSQL)
+	if err != nil {
+		return errors.New(fmt.Sprintf("create sql table %s err: %v", tokensTable, err))
+	}
+	return nil
+}
+
+// UpsertTokens inserts the tokens, replacing the stored metadata of known
+// addresses.
+func (p *PostgresDB) UpsertTokens(tokens []*types.Token) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return errors.New(fmt.Sprintf("db begin err: %v", err))
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (address, name, symbol, coinid, decimals, startblock) VALUES ($1,$2,$3,$4,$5,$6) "+
+		"ON CONFLICT (address) DO UPDATE SET name = EXCLUDED.name, symbol = EXCLUDED.symbol, coinid = EXCLUDED.coinid, "+
+		"decimals = EXCLUDED.decimals, startblock = EXCLUDED.startblock", tokensTable))
+	if err != nil {
+		return errors.New(fmt.Sprintf("db prepare err: %v", err))
+	}
+	defer stmt.Close()
+
+	for _, t := range tokens {
+		if _, err := stmt.Exec(t.Address.String(), t.Name, t.Symbol, t.CoinID, t.Decimals, t.StartBlock); err != nil {
+			return errors.New(fmt.Sprintf("db stmt exec err: %v", err))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New(fmt.Sprintf("db tx commit err: %v", err))
+	}
+	return nil
+}
+
+// ReadTokens returns the stored token metadata.
+func (p *PostgresDB) ReadTokens() ([]*types.Token, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	rows, err := p.db.Query(fmt.Sprintf("SELECT address, name, symbol, coinid, decimals, startblock FROM %s", tokensTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*types.Token, 0)
+	for rows.Next() {
+		var address string
+		t := &types.Token{}
+		if err := rows.Scan(&address, &t.Name, &t.Symbol, &t.CoinID, &t.Decimals, &t.StartBlock); err != nil {
+			return nil, err
+		}
+		t.Address = common.HexToAddress(address)
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
@@ -0,0 +1,195 @@
+// Package e2e runs the token, transaction and statistics pipeline against a
+// mock Celo node and a local Postgres or its stand-in.
+package e2e
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/client/mocknode"
+	"github.com/xuxinlai2002/creda-celo-balance/config"
+	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/db/dbtest"
+	"github.com/xuxinlai2002/creda-celo-balance/signal"
+	"github.com/xuxinlai2002/creda-celo-balance/statistics/account"
+	"github.com/xuxinlai2002/creda-celo-balance/tokens"
+	"github.com/xuxinlai2002/creda-celo-balance/transactions"
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+var (
+	alice = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	carol = common.HexToAddress("0x00000000000000000000000000000000000ca201")
+	cUSD  = common.HexToAddress("0x765DE816845861e75A25fCA122bb6898B8B1282a")
+)
+
+func ether(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), big.NewInt(1e18))
+}
+
+// postgresConfig returns a config for a fresh database on the Postgres
+// described by the standard PGHOST, PGPORT, PGUSER and PGPASSWORD variables,
+// or on the stand-in of package dbtest when PGHOST is not set.
+func postgresConfig(t *testing.T) *config.Config {
+	host := os.Getenv("PGHOST")
+	if host == "" {
+		host = dbtest.Use(t)
+	}
+	port := uint64(5432)
+	if s := os.Getenv("PGPORT"); s != "" {
+		var err error
+		port, err = strconv.ParseUint(s, 10, 32)
+		require.NoError(t, err)
+	}
+	cfg := &config.Config{
+		PostgresDBName:   fmt.Sprintf("creda_e2e_%d", time.Now().UnixNano()),
+		PostgresHost:     host,
+		PostgresPort:     uint32(port),
+		PostgresUser:     os.Getenv("PGUSER"),
+		PostgresPassword: os.Getenv("PGPASSWORD"),
+	}
+	require.NoError(t, db.CreateDataBase(cfg.PostgresDBName, cfg.PostgresUser, cfg.PostgresPassword, cfg.PostgresHost, cfg.PostgresPort))
+	t.Cleanup(func() {
+		conn, err := sql.Open(db.Driver, fmt.Sprintf("user=%s sslmode=disable password=%s host=%s port=%d",
+			cfg.PostgresUser, cfg.PostgresPassword, cfg.PostgresHost, cfg.PostgresPort))
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Exec("DROP DATABASE IF EXISTS " + cfg.PostgresDBName)
+	})
+	return cfg
+}
+
+// chdir runs the test in a temporary directory, where legacy progress files
+// would be imported from.
+func chdir(t *testing.T) string {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+// TestPipeline indexes a scripted chain twice with the token and transaction
+// services and checks the balances valued by the statistics run.
+func TestPipeline(t *testing.T) {
+	cfg := postgresConfig(t)
+	dir := chdir(t)
+
+	// Record tables are named after the local date of a block.
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	// 2020-05-01 12:00:00 UTC.
+	const day = 1588334400
+	node := mocknode.New(day)
+	defer node.Close()
+	// Not a Celo network, so opening balances are snapshot at genesis
+	// instead of read from the genesis allocations.
+	node.ChainID = 1337
+	node.AddBlock(day+5,
+		&mocknode.Tx{From: carol, To: &alice, Value: ether(5)},
+		&mocknode.Tx{
+			From: alice,
+			To:   &cUSD,
+			Logs: []*mocknode.Log{mocknode.TransferLog(cUSD, common.ZeroAddress, alice, ether(100))},
+		},
+	)
+	node.AddBlock(day + 10)
+	node.SetBalance(carol, 0, ether(15))
+	node.SetBalance(carol, 1, ether(10))
+	node.SetToken(cUSD, "cUSD", 18)
+
+	prices := filepath.Join(dir, "price.txt")
+	require.NoError(t, os.WriteFile(prices, []byte("5567 2020-05-01 2\n7236 2020-05-01 1\n"), 0644))
+
+	registry := filepath.Join(dir, "tokens.json")
+	require.NoError(t, os.WriteFile(registry, []byte(`[{"address": "`+cUSD.Hex()+`", "name": "cUSD", "coinId": 7236, "decimals": 18}]`), 0644))
+
+	cfg.HTTP = node.URL
+	cfg.TokenRegistry = registry
+	cfg.StartBlock, cfg.EndBlock = 1, 2
+	cfg.LogRangeSize, cfg.MaxLogRangeSize = 10, 10
+	cfg.PullStartHeight, cfg.PullEndHeight = 1, 2
+	cfg.StatisticsDateBegin, cfg.StatisticsDateEnd = "2020-05-01", "2020-05-01"
+	cfg.CoinHistoryPrice = prices
+
+	conn, err := sql.Open(db.Driver, fmt.Sprintf("user=%s dbname=%s sslmode=disable password=%s host=%s port=%d",
+		cfg.PostgresUser, cfg.PostgresDBName, cfg.PostgresPassword, cfg.PostgresHost, cfg.PostgresPort))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The second run replays the range from scratch, which must not
+	// duplicate any transfer.
+	var wg sync.WaitGroup
+	for run := 0; run < 2; run++ {
+		if run > 0 {
+			_, err := conn.Exec("DELETE FROM indexer_checkpoints")
+			require.NoError(t, err)
+		}
+
+		service, err := tokens.NewService(cfg, &wg)
+		require.NoError(t, err)
+		require.NoError(t, service.Start(signal.Interceptor{}))
+		wg.Wait()
+
+		pull, err := transactions.New(cfg, &wg)
+		require.NoError(t, err)
+		pull.Start(signal.Interceptor{})
+		wg.Wait()
+	}
+
+	acc, err := account.New(cfg, &wg)
+	require.NoError(t, err)
+	wg.Add(1)
+	acc.Start()
+	wg.Wait()
+
+	rows, err := conn.Query("SELECT address, value FROM ods_balance_20200501")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	values := make(map[string]float64)
+	for rows.Next() {
+		var address, value string
+		require.NoError(t, rows.Scan(&address, &value))
+		values[address], err = strconv.ParseFloat(value, 64)
+		require.NoError(t, err)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, map[string]float64{
+		alice.String(): 5*2 + 100*1,
+		carol.String(): 10 * 2,
+	}, values)
+
+	coinRows, err := conn.Query("SELECT address, coinid, value FROM ods_coin_balance_20200501")
+	require.NoError(t, err)
+	defer coinRows.Close()
+
+	balances := make(map[string]string)
+	for coinRows.Next() {
+		var address, value string
+		var coinID uint64
+		require.NoError(t, coinRows.Scan(&address, &coinID, &value))
+		balances[fmt.Sprintf("%s/%d", address, coinID)] = value
+	}
+	require.NoError(t, coinRows.Err())
+	require.Equal(t, map[string]string{
+		fmt.Sprintf("%s/%d", alice.String(), types.CELO_COINID): ether(5).String(),
+		fmt.Sprintf("%s/%d", alice.String(), 7236):              ether(100).String(),
+		fmt.Sprintf("%s/%d", carol.String(), types.CELO_COINID): ether(10).String(),
+	}, balances)
+}
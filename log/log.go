@@ -2,8 +2,14 @@ package log
 
 import (
 	"github.com/btcsuite/btclog"
+	"github.com/xuxinlai2002/creda-celo-balance/audit"
 	"github.com/xuxinlai2002/creda-celo-balance/build"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/metadata"
+	"github.com/xuxinlai2002/creda-celo-balance/opening"
+	"github.com/xuxinlai2002/creda-celo-balance/reorg"
 	"github.com/xuxinlai2002/creda-celo-balance/signal"
+	"github.com/xuxinlai2002/creda-celo-balance/snapshot"
 	"github.com/xuxinlai2002/creda-celo-balance/tokens"
 	"github.com/xuxinlai2002/creda-celo-balance/transactions"
 )
@@ -82,8 +88,14 @@ func SetupLoggers(root *build.RotatingLogWriter, interceptor signal.Interceptor)
 	// in sub packages.
 	signal.UseLogger(MainLog)
 
+	AddSubLogger(root, client.Subsystem, interceptor, client.UseLogger)
+	AddSubLogger(root, reorg.Subsystem, interceptor, reorg.UseLogger)
+	AddSubLogger(root, metadata.Subsystem, interceptor, metadata.UseLogger)
 	AddSubLogger(root, tokens.Subsystem, interceptor, tokens.UseLogger)
 	AddSubLogger(root, transactions.Subsystem, interceptor, transactions.UseLogger)
+	AddSubLogger(root, opening.Subsystem, interceptor, opening.UseLogger)
+	AddSubLogger(root, snapshot.Subsystem, interceptor, snapshot.UseLogger)
+	AddSubLogger(root, audit.Subsystem, interceptor, audit.UseLogger)
 }
 
 // AddSubLogger is a helper method to conveniently create and register the
@@ -1,17 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	godebug "runtime/debug"
 	"sync"
 
+	"github.com/xuxinlai2002/creda-celo-balance/audit"
 	"github.com/xuxinlai2002/creda-celo-balance/build"
 	"github.com/xuxinlai2002/creda-celo-balance/config"
 	"github.com/xuxinlai2002/creda-celo-balance/db"
 	"github.com/xuxinlai2002/creda-celo-balance/log"
 	"github.com/xuxinlai2002/creda-celo-balance/signal"
+	"github.com/xuxinlai2002/creda-celo-balance/snapshot"
 	"github.com/xuxinlai2002/creda-celo-balance/tokens"
 	"github.com/xuxinlai2002/creda-celo-balance/transactions"
 )
@@ -70,6 +73,32 @@ func main() {
 		//panic(any(err.Error()))
 	}
 
+	if cfg.SnapshotBlock > 0 {
+		snapshotter, err := snapshot.New(cfg)
+		if err != nil {
+			log.MainLog.Errorf("new snapshotter err: %v", err)
+			panic(any(err.Error()))
+		}
+		if err := snapshotter.Run(context.Background()); err != nil {
+			log.MainLog.Errorf("snapshot at block %v failed: %v", cfg.SnapshotBlock, err)
+			panic(any(err.Error()))
+		}
+		return
+	}
+
+	if cfg.AuditDate != "" {
+		auditor, err := audit.New(cfg)
+		if err != nil {
+			log.MainLog.Errorf("new auditor err: %v", err)
+			panic(any(err.Error()))
+		}
+		if err := auditor.Run(context.Background()); err != nil {
+			log.MainLog.Errorf("audit of %v failed: %v", cfg.AuditDate, err)
+			panic(any(err.Error()))
+		}
+		return
+	}
+
 	tokensService, err := tokens.NewService(cfg, &wg)
 	if err != nil {
 		log.MainLog.Errorf("new tokens services err: %v", err)
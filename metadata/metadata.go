@@ -0,0 +1,179 @@
+// Package metadata owns the metadata of the tracked tokens: it loads the
+// token registry, verifies it against the chain and persists it for the
+// statistics job.
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/common/hexutil"
+	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+var (
+	symbolSelector   = crypto.Keccak256([]byte("symbol()"))[:4]
+	decimalsSelector = crypto.Keccak256([]byte("decimals()"))[:4]
+)
+
+// registryEntry is one token of the registry file.
+type registryEntry struct {
+	Address    string `json:"address"`
+	Name       string `json:"name"`
+	CoinID     uint64 `json:"coinId"`
+	Decimals   uint8  `json:"decimals"`
+	StartBlock uint64 `json:"startBlock,omitempty"`
+}
+
+// Load reads the tracked tokens from the JSON registry file at path, keyed by
+// contract address.
+func Load(path string) (map[common.Address]*types.Token, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []registryEntry
+	if err := json.Unmarshal(bytes.TrimPrefix(file, []byte("\xef\xbb\xbf")), &entries); err != nil {
+		return nil, fmt.Errorf("token registry %s err: %v", path, err)
+	}
+
+	tokens := make(map[common.Address]*types.Token, len(entries))
+	for _, e := range entries {
+		if !common.IsHexAddress(e.Address) {
+			return nil, fmt.Errorf("token registry %s: invalid address %q", path, e.Address)
+		}
+		address := common.HexToAddress(e.Address)
+		if _, ok := tokens[address]; ok {
+			return nil, fmt.Errorf("token registry %s: duplicate token %v", path, address)
+		}
+		if e.Name == "" || e.CoinID == 0 {
+			return nil, fmt.Errorf("token registry %s: token %v needs a name and a coin id", path, address)
+		}
+		tokens[address] = &types.Token{
+			Address:    address,
+			Name:       e.Name,
+			CoinID:     e.CoinID,
+			Decimals:   e.Decimals,
+			StartBlock: e.StartBlock,
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("token registry %s is empty", path)
+	}
+	if _, err := Decimals(tokenList(tokens)); err != nil {
+		return nil, fmt.Errorf("token registry %s: %v", path, err)
+	}
+	return tokens, nil
+}
+
+// Verify compares the tokens with the symbol() and decimals() of their
+// contracts and fills in their symbols. A symbol differing from the
+// configured name is only logged, decimals have to match.
+func Verify(ctx context.Context, cli *client.Client, tokens map[common.Address]*types.Token) error {
+	for address, token := range tokens {
+		ret, err := cli.CallContract(ctx, callArgs(address, decimalsSelector), nil)
+		if err != nil {
+			return fmt.Errorf("token %s decimals() err: %v", token.Name, err)
+		}
+		if len(ret) != 32 || new(big.Int).SetBytes(ret).Cmp(big.NewInt(int64(token.Decimals))) != 0 {
+			return fmt.Errorf("token %s %v has decimals %v configured, contract returned %x",
+				token.Name, address, token.Decimals, ret)
+		}
+
+		ret, err = cli.CallContract(ctx, callArgs(address, symbolSelector), nil)
+		if err != nil {
+			log.Warnf("token %s symbol() err: %v", token.Name, err)
+			continue
+		}
+		symbol, err := decodeSymbol(ret)
+		if err != nil {
+			log.Warnf("token %s symbol() returned %x: %v", token.Name, ret, err)
+			continue
+		}
+		if symbol != token.Name {
+			log.Infof("token %s %v has symbol %s", token.Name, address, symbol)
+		}
+		token.Symbol = symbol
+	}
+	return nil
+}
+
+// Store persists the tokens in the tokens table.
+func Store(database *db.PostgresDB, tokens map[common.Address]*types.Token) error {
+	if err := database.CreateTokenTable(); err != nil {
+		return err
+	}
+	return database.UpsertTokens(tokenList(tokens))
+}
+
+// LoadDecimals reads the decimals of every coin from the tokens table.
+func LoadDecimals(database *db.PostgresDB) (map[types.COINID]uint8, error) {
+	tokens, err := database.ReadTokens()
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("tokens table is empty, run the token indexer first")
+	}
+	return Decimals(tokens)
+}
+
+// Decimals maps the coin ids of tokens, and of native CELO, to their
+// decimals. Tokens sharing a coin id must have the same decimals.
+func Decimals(tokens []*types.Token) (map[types.COINID]uint8, error) {
+	decimals := map[types.COINID]uint8{types.CELO_COINID: types.CELO_DECIMALS}
+	for _, token := range tokens {
+		coinID := types.COINID(token.CoinID)
+		if d, ok := decimals[coinID]; ok && d != token.Decimals {
+			return nil, fmt.Errorf("coin %v has decimals %v and %v", coinID, d, token.Decimals)
+		}
+		decimals[coinID] = token.Decimals
+	}
+	return decimals, nil
+}
+
+func tokenList(tokens map[common.Address]*types.Token) []*types.Token {
+	list := make([]*types.Token, 0, len(tokens))
+	for _, token := range tokens {
+		list = append(list, token)
+	}
+	return list
+}
+
+func callArgs(to common.Address, data []byte) map[string]interface{} {
+	return map[string]interface{}{
+		"to":   to,
+		"data": hexutil.Bytes(data),
+	}
+}
+
+// decodeSymbol decodes the return value of symbol(), an ABI string or a
+// bytes32 for some older tokens.
+func decodeSymbol(ret []byte) (string, error) {
+	if len(ret) == 32 {
+		return strings.TrimRight(string(ret), "\x00"), nil
+	}
+	if len(ret) < 64 {
+		return "", errors.New("short return value")
+	}
+	offset := new(big.Int).SetBytes(ret[:32])
+	if !offset.IsUint64() || offset.Uint64()+32 > uint64(len(ret)) {
+		return "", errors.New("invalid string offset")
+	}
+	start := offset.Uint64() + 32
+	length := new(big.Int).SetBytes(ret[start-32 : start])
+	if !length.IsUint64() || start+length.Uint64() > uint64(len(ret)) {
+		return "", errors.New("invalid string length")
+	}
+	return string(ret[start : start+length.Uint64()]), nil
+}
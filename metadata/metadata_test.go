@@ -0,0 +1,108 @@
+package metadata
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/client/mocknode"
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+var (
+	cUSD    = common.HexToAddress("0x765DE816845861e75A25fCA122bb6898B8B1282a")
+	cEUR    = common.HexToAddress("0xD8763CBa276a3738E6DE85b4b3bF5FDed6D6cA73")
+	unknown = common.HexToAddress("0x000000000000000000000000000000000000dead")
+)
+
+func testTokens() map[common.Address]*types.Token {
+	return map[common.Address]*types.Token{
+		cUSD: {Address: cUSD, Name: "cUSD", CoinID: 7236, Decimals: 18},
+		cEUR: {Address: cEUR, Name: "cEUR", CoinID: 9467, Decimals: 18},
+	}
+}
+
+// TestLoad tests parsing and validation of the registry file.
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	for _, tc := range []struct {
+		registry string
+		err      string
+	}{
+		{`[{"address": "0x765DE816845861e75A25fCA122bb6898B8B1282a", "name": "cUSD", "coinId": 7236, "decimals": 18, "startBlock": 2962}]`, ""},
+		{`[{"address": "0x765DE8", "name": "cUSD", "coinId": 7236, "decimals": 18}]`, "invalid address"},
+		{`[{"address": "0x765DE816845861e75A25fCA122bb6898B8B1282a", "coinId": 7236, "decimals": 18}]`, "needs a name"},
+		{`[{"address": "0x765DE816845861e75A25fCA122bb6898B8B1282a", "name": "cUSD", "coinId": 7236, "decimals": 18},
+		  {"address": "0x765de816845861e75a25fca122bb6898b8b1282a", "name": "cUSD", "coinId": 7236, "decimals": 18}]`, "duplicate token"},
+		{`[{"address": "0x765DE816845861e75A25fCA122bb6898B8B1282a", "name": "cUSD", "coinId": 7236, "decimals": 18},
+		  {"address": "0xD8763CBa276a3738E6DE85b4b3bF5FDed6D6cA73", "name": "cEUR", "coinId": 7236, "decimals": 6}]`, "coin 7236 has decimals"},
+		{`[]`, "is empty"},
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(tc.registry), 0644))
+		tokens, err := Load(path)
+		if tc.err != "" {
+			require.ErrorContains(t, err, tc.err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, map[common.Address]*types.Token{
+			cUSD: {Address: cUSD, Name: "cUSD", CoinID: 7236, Decimals: 18, StartBlock: 2962},
+		}, tokens)
+	}
+
+	// The registry shipped with the indexer has to stay loadable.
+	_, err := Load(filepath.Join("..", "tokens.json"))
+	require.NoError(t, err)
+}
+
+// TestVerify tests that configured decimals have to match the contract while
+// a differing symbol is accepted.
+func TestVerify(t *testing.T) {
+	node := mocknode.New(1000)
+	defer node.Close()
+	node.SetToken(cUSD, "cUSD", 18)
+	node.SetToken(cEUR, "EURC", 18)
+	cli, err := client.Dial(node.URL)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	tokens := testTokens()
+	require.NoError(t, Verify(context.Background(), cli, tokens))
+	require.Equal(t, "cUSD", tokens[cUSD].Symbol)
+	require.Equal(t, "EURC", tokens[cEUR].Symbol)
+
+	node.SetToken(cEUR, "cEUR", 6)
+	require.ErrorContains(t, Verify(context.Background(), cli, testTokens()), "has decimals 18 configured")
+
+	tokens = map[common.Address]*types.Token{unknown: {Address: unknown, Name: "NONE", CoinID: 1, Decimals: 18}}
+	require.Error(t, Verify(context.Background(), cli, tokens))
+}
+
+// TestDecodeSymbol tests decoding of string and bytes32 symbols.
+func TestDecodeSymbol(t *testing.T) {
+	symbol, err := decodeSymbol(mocknode.EncodeString("cUSD"))
+	require.NoError(t, err)
+	require.Equal(t, "cUSD", symbol)
+
+	symbol, err = decodeSymbol(common.RightPadBytes([]byte("MKR"), 32))
+	require.NoError(t, err)
+	require.Equal(t, "MKR", symbol)
+
+	_, err = decodeSymbol(make([]byte, 40))
+	require.Error(t, err)
+}
+
+// TestDecimals tests that decimals are keyed by coin id, native CELO
+// included.
+func TestDecimals(t *testing.T) {
+	decimals, err := Decimals(tokenList(testTokens()))
+	require.NoError(t, err)
+	require.Equal(t, map[types.COINID]uint8{types.CELO_COINID: 18, 7236: 18, 9467: 18}, decimals)
+
+	_, err = Decimals([]*types.Token{{Address: unknown, Name: "CELO6", CoinID: types.CELO_COINID, Decimals: 6}})
+	require.Error(t, err)
+}
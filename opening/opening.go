@@ -0,0 +1,319 @@
+// Package opening seeds the balances and LockedGold positions accounts held
+// before the indexers started, which the statistics run opens every account
+// with before applying the indexed records.
+package opening
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/celo-org/celo-blockchain/common"
+	registry "github.com/celo-org/celo-blockchain/contracts/config"
+	"github.com/celo-org/celo-blockchain/core"
+	"github.com/celo-org/celo-blockchain/params"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/config"
+	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+// SeedBlock returns the block whose state an indexer starting at start
+// builds on, genesis for an indexer starting at 0 or 1.
+func SeedBlock(start uint64) uint64 {
+	if start == 0 {
+		return 0
+	}
+	return start - 1
+}
+
+// genesisAlloc returns the genesis allocations of the Celo networks, nil for
+// other chains.
+func genesisAlloc(chainID *big.Int) core.GenesisAlloc {
+	switch chainID.Uint64() {
+	case params.MainnetNetworkId:
+		return core.DefaultGenesisBlock().Alloc
+	case params.BaklavaNetworkId:
+		return core.DefaultBaklavaGenesisBlock().Alloc
+	case params.AlfajoresNetworkId:
+		return core.DefaultAlfajoresGenesisBlock().Alloc
+	}
+	return nil
+}
+
+// Seeder stores the opening balance of every account an indexer records,
+// the first time it records it. Native CELO and LockedGold positions are
+// read at the block the tx indexer builds on, or taken from the genesis
+// allocations when it starts at genesis, and tokens at the block the token
+// indexer builds on.
+type Seeder struct {
+	cli *client.Client
+	db  *db.PostgresDB
+
+	// nativeBlock is the block native balances and positions are read at.
+	nativeBlock uint64
+	// genesis is set when native balances come from the genesis
+	// allocations, so they are not read from the node.
+	genesis bool
+	// lockedGold is the LockedGold contract at nativeBlock, once resolved.
+	lockedGold *common.Address
+	// tokens are the tracked tokens other than GoldToken by coin id.
+	tokens map[uint64][]*types.Token
+	// tokenBlocks are the blocks token balances are read at.
+	tokenBlocks map[common.Address]uint64
+
+	// seeded are the accounts holding an opening balance, by token.
+	seeded map[common.Address]map[common.Address]bool
+	// positioned are the accounts holding an opening position.
+	positioned map[common.Address]bool
+}
+
+// New returns a seeder for the accounts recorded in the coins of tokens and
+// native CELO. On a Celo network indexed from genesis it stores the genesis
+// allocations.
+func New(ctx context.Context, cfg *config.Config, cli *client.Client, database *db.PostgresDB, tokens map[common.Address]*types.Token) (*Seeder, error) {
+	if err := database.CreateOpeningTable(); err != nil {
+		return nil, err
+	}
+	s := &Seeder{
+		cli:         cli,
+		db:          database,
+		nativeBlock: SeedBlock(cfg.PullStartHeight),
+		tokens:      make(map[uint64][]*types.Token),
+		tokenBlocks: make(map[common.Address]uint64),
+		seeded:      make(map[common.Address]map[common.Address]bool),
+		positioned:  make(map[common.Address]bool),
+	}
+	for address, token := range tokens {
+		// GoldToken balances are the native CELO balances.
+		if token.CoinID == types.CELO_COINID {
+			continue
+		}
+		s.tokens[token.CoinID] = append(s.tokens[token.CoinID], token)
+		start := cfg.StartBlock
+		if token.StartBlock > start {
+			start = token.StartBlock
+		}
+		s.tokenBlocks[address] = SeedBlock(start)
+	}
+	for _, list := range s.tokens {
+		sort.Slice(list, func(i, j int) bool { return bytes.Compare(list[i].Address[:], list[j].Address[:]) < 0 })
+	}
+
+	openings, err := database.ReadOpenings()
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range openings {
+		s.markSeeded(b)
+	}
+	positions, err := database.ReadOpeningPositions()
+	if err != nil {
+		return nil, err
+	}
+	for _, position := range positions {
+		s.positioned[position.Address] = true
+	}
+
+	if s.nativeBlock != 0 {
+		return s, nil
+	}
+	chainID, err := cli.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	alloc := genesisAlloc(chainID)
+	if alloc == nil {
+		return s, nil
+	}
+	s.genesis = true
+	balances := make([]*types.Balance, 0)
+	for account, genesis := range alloc {
+		if genesis.Balance == nil || genesis.Balance.Sign() <= 0 || s.seeded[common.ZeroAddress][account] {
+			continue
+		}
+		balances = append(balances, &types.Balance{Address: account, CoinID: types.CELO_COINID, Value: genesis.Balance})
+	}
+	if err := s.store(balances); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Seed stores the opening balances of the accounts sending or receiving a
+// coin in records that have none yet. LockedGold records leave balances
+// alone, their accounts get an opening position from Positions.
+func (s *Seeder) Seed(ctx context.Context, records map[string][]*types.TokenRecord) error {
+	accounts := make(map[uint64]map[common.Address]bool)
+	for _, list := range records {
+		for _, r := range list {
+			if r.FrameType == types.LOCKED_GOLD_FRAME {
+				continue
+			}
+			for _, account := range []common.Address{r.From, r.To} {
+				if account == common.ZeroAddress {
+					continue
+				}
+				if accounts[r.CoinID] == nil {
+					accounts[r.CoinID] = make(map[common.Address]bool)
+				}
+				accounts[r.CoinID][account] = true
+			}
+		}
+	}
+
+	balances := make([]*types.Balance, 0)
+	for coinID, set := range accounts {
+		if coinID == types.CELO_COINID {
+			if s.genesis {
+				continue
+			}
+			snapshot, err := s.snapshot(ctx, common.ZeroAddress, coinID, s.nativeBlock, set)
+			if err != nil {
+				return err
+			}
+			balances = append(balances, snapshot...)
+			continue
+		}
+		for _, token := range s.tokens[coinID] {
+			snapshot, err := s.snapshot(ctx, token.Address, coinID, s.tokenBlocks[token.Address], set)
+			if err != nil {
+				return err
+			}
+			balances = append(balances, snapshot...)
+		}
+	}
+	return s.store(balances)
+}
+
+// Positioned reports whether account holds an opening LockedGold position,
+// that is whether it was seen in a LockedGold record before.
+func (s *Seeder) Positioned(account common.Address) bool {
+	return s.positioned[account]
+}
+
+// Holders returns the accounts holding an opening LockedGold position in
+// ascending order.
+func (s *Seeder) Holders() []common.Address {
+	holders := make([]common.Address, 0, len(s.positioned))
+	for account := range s.positioned {
+		holders = append(holders, account)
+	}
+	sort.Slice(holders, func(i, j int) bool { return bytes.Compare(holders[i][:], holders[j][:]) < 0 })
+	return holders
+}
+
+// Positions returns the positions at nativeBlock of the accounts of the
+// LockedGold records in records without an opening position, zero while
+// LockedGold is not deployed. Zero positions are returned as well, so the
+// accounts become known holders. They are not stored, the tx indexer commits
+// them together with the records and marks them with MarkPositioned, so an
+// account is positioned exactly when its first records are committed.
+func (s *Seeder) Positions(ctx context.Context, records map[string][]*types.TokenRecord) ([]*types.Position, error) {
+	missing := make([]common.Address, 0)
+	seen := make(map[common.Address]bool)
+	for _, list := range records {
+		for _, r := range list {
+			if r.FrameType != types.LOCKED_GOLD_FRAME || s.positioned[r.From] || seen[r.From] {
+				continue
+			}
+			seen[r.From] = true
+			missing = append(missing, r.From)
+		}
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	sort.Slice(missing, func(i, j int) bool { return bytes.Compare(missing[i][:], missing[j][:]) < 0 })
+
+	number := new(big.Int).SetUint64(s.nativeBlock)
+	if s.lockedGold == nil {
+		address, err := s.cli.RegisteredAddress(ctx, registry.LockedGoldRegistryId, number)
+		if err != nil {
+			return nil, err
+		}
+		s.lockedGold = &address
+	}
+	positions := make([]*types.Position, len(missing))
+	for i, account := range missing {
+		positions[i] = &types.Position{BlockNumber: s.nativeBlock, Address: account, Locked: big.NewInt(0), Pending: big.NewInt(0)}
+	}
+	if *s.lockedGold != common.ZeroAddress {
+		locked, pending, errs := s.cli.LockedGoldAt(ctx, *s.lockedGold, missing, number)
+		for i, account := range missing {
+			if errs[i] != nil {
+				return nil, errors.New(fmt.Sprintf("LockedGold position of %v at %v err: %v", account, number, errs[i]))
+			}
+			positions[i].Locked, positions[i].Pending = locked[i], pending[i]
+		}
+	}
+	return positions, nil
+}
+
+// MarkPositioned marks the accounts of positions, once committed, as holding
+// an opening position.
+func (s *Seeder) MarkPositioned(positions []*types.Position) {
+	for _, position := range positions {
+		s.positioned[position.Address] = true
+	}
+	if len(positions) > 0 {
+		log.Debugf("seeded %d opening positions", len(positions))
+	}
+}
+
+// snapshot reads the balances in token, the zero address meaning native
+// CELO, at block number of the accounts not seeded yet.
+func (s *Seeder) snapshot(ctx context.Context, token common.Address, coinID, number uint64, accounts map[common.Address]bool) ([]*types.Balance, error) {
+	missing := make([]common.Address, 0)
+	for account := range accounts {
+		if !s.seeded[token][account] {
+			missing = append(missing, account)
+		}
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	sort.Slice(missing, func(i, j int) bool { return bytes.Compare(missing[i][:], missing[j][:]) < 0 })
+
+	var values []*big.Int
+	var errs []error
+	if token == common.ZeroAddress {
+		values, errs = s.cli.BalancesAt(ctx, missing, new(big.Int).SetUint64(number))
+	} else {
+		values, errs = s.cli.TokenBalancesAt(ctx, token, missing, new(big.Int).SetUint64(number))
+	}
+	balances := make([]*types.Balance, len(missing))
+	for i, account := range missing {
+		if errs[i] != nil {
+			return nil, errors.New(fmt.Sprintf("balance of %v in %v at %v err: %v", account, token, number, errs[i]))
+		}
+		balances[i] = &types.Balance{BlockNumber: number, Address: account, Token: token, CoinID: coinID, Value: values[i]}
+	}
+	return balances, nil
+}
+
+// store stores balances and marks their accounts seeded. Zero balances are
+// stored as well, so the account is not read again.
+func (s *Seeder) store(balances []*types.Balance) error {
+	if len(balances) == 0 {
+		return nil
+	}
+	if err := s.db.InsertOpenings(balances); err != nil {
+		return err
+	}
+	for _, b := range balances {
+		s.markSeeded(b)
+	}
+	log.Debugf("seeded %d opening balances", len(balances))
+	return nil
+}
+
+func (s *Seeder) markSeeded(b *types.Balance) {
+	if s.seeded[b.Token] == nil {
+		s.seeded[b.Token] = make(map[common.Address]bool)
+	}
+	s.seeded[b.Token][b.Address] = true
+}
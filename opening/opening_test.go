@@ -0,0 +1,144 @@
+package opening
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	registry "github.com/celo-org/celo-blockchain/contracts/config"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/client/mocknode"
+	"github.com/xuxinlai2002/creda-celo-balance/config"
+	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/db/dbtest"
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+var (
+	alice = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	bob   = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+	carol = common.HexToAddress("0x00000000000000000000000000000000000ca201")
+	cUSD  = common.HexToAddress("0x765DE816845861e75A25fCA122bb6898B8B1282a")
+	celo  = common.HexToAddress("0x471EcE3750Da237f93B8E339c536989b8978a438")
+)
+
+const cUSDCoinID = 7236
+
+func setup(t *testing.T, node *mocknode.Node) (*client.Client, *db.PostgresDB) {
+	cli, err := client.Dial(node.URL)
+	require.NoError(t, err)
+	t.Cleanup(cli.Close)
+	database, err := db.NewDB("creda", "", "", dbtest.Use(t), 5432)
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	return cli, database
+}
+
+func openings(t *testing.T, database *db.PostgresDB) map[common.Address]map[common.Address]string {
+	balances, err := database.ReadOpenings()
+	require.NoError(t, err)
+	got := make(map[common.Address]map[common.Address]string)
+	for _, b := range balances {
+		if got[b.Token] == nil {
+			got[b.Token] = make(map[common.Address]string)
+		}
+		got[b.Token][b.Address] = b.Value.String()
+	}
+	return got
+}
+
+// TestSeed tests that accounts are seeded once, with native CELO and
+// LockedGold positions read at the block before the tx indexer starts and
+// tokens at the block before the token indexer starts, and that committed
+// positions are not read again.
+func TestSeed(t *testing.T) {
+	node := mocknode.New(1588334400)
+	defer node.Close()
+	node.ChainID = 1337
+	node.AddBlock(1588334405)
+	node.AddBlock(1588334410)
+	node.SetBalance(alice, 0, big.NewInt(1000))
+	node.SetBalance(alice, 1, big.NewInt(700))
+	node.SetBalance(bob, 1, big.NewInt(3))
+	node.SetTokenBalance(cUSD, bob, big.NewInt(20))
+	lockedGold := common.HexToAddress("0x0000000000000000000000000000000000001001")
+	node.SetRegistered(registry.LockedGoldRegistryId, lockedGold)
+	node.SetLockedGold(lockedGold, carol, 0, big.NewInt(50), big.NewInt(4), big.NewInt(6))
+	cli, database := setup(t, node)
+
+	cfg := &config.Config{PullStartHeight: 2, StartBlock: 1}
+	tokens := map[common.Address]*types.Token{
+		cUSD: {Address: cUSD, CoinID: cUSDCoinID, StartBlock: 3},
+		celo: {Address: celo, CoinID: types.CELO_COINID},
+	}
+	s, err := New(context.Background(), cfg, cli, database, tokens)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), s.nativeBlock)
+	require.Equal(t, uint64(2), s.tokenBlocks[cUSD])
+	require.NotContains(t, s.tokenBlocks, celo)
+
+	records := map[string][]*types.TokenRecord{
+		"tx_20200501": {
+			{From: alice, To: bob, CoinID: types.CELO_COINID, Value: big.NewInt(1)},
+			{From: common.ZeroAddress, To: alice, CoinID: cUSDCoinID, Value: big.NewInt(1)},
+			// Position records leave liquid balances alone.
+			{From: carol, To: carol, CoinID: types.CELO_COINID, Value: big.NewInt(1),
+				TraceAddress: types.GOLD_UNLOCKED, FrameType: types.LOCKED_GOLD_FRAME},
+		},
+		"event20200501": {{From: alice, To: bob, CoinID: cUSDCoinID, Value: big.NewInt(1)}},
+	}
+	require.NoError(t, s.Seed(context.Background(), records))
+	require.Equal(t, map[common.Address]map[common.Address]string{
+		common.ZeroAddress: {alice: "700", bob: "3"},
+		cUSD:               {alice: "0", bob: "20"},
+	}, openings(t, database))
+	require.Equal(t, 2, node.Calls("eth_getBalance"))
+	positions, err := s.Positions(context.Background(), records)
+	require.NoError(t, err)
+	require.Equal(t, []*types.Position{{BlockNumber: 1, Address: carol, Locked: big.NewInt(50), Pending: big.NewInt(10)}}, positions)
+	require.Equal(t, 5, node.Calls("eth_call"))
+	// The tx indexer commits the positions with the records.
+	require.NoError(t, database.InsertOpeningPositions(positions))
+	s.MarkPositioned(positions)
+
+	// A new seeder only reads the accounts seen for the first time, carol
+	// now sending CELO.
+	s, err = New(context.Background(), cfg, cli, database, tokens)
+	require.NoError(t, err)
+	records["tx_20200501"] = append(records["tx_20200501"],
+		&types.TokenRecord{From: bob, To: carol, CoinID: types.CELO_COINID, Value: big.NewInt(1)})
+	require.NoError(t, s.Seed(context.Background(), records))
+	require.Equal(t, "0", openings(t, database)[common.ZeroAddress][carol])
+	require.Equal(t, 3, node.Calls("eth_getBalance"))
+	positions, err = s.Positions(context.Background(), records)
+	require.NoError(t, err)
+	require.Empty(t, positions)
+	require.Equal(t, 5, node.Calls("eth_call"))
+}
+
+// TestSeedGenesis tests that a Celo network indexed from genesis is seeded
+// with the genesis allocations instead of reading native balances.
+func TestSeedGenesis(t *testing.T) {
+	node := mocknode.New(1588334400)
+	defer node.Close()
+	cli, database := setup(t, node)
+
+	s, err := New(context.Background(), &config.Config{PullStartHeight: 1}, cli, database, nil)
+	require.NoError(t, err)
+	require.True(t, s.genesis)
+	alloc := genesisAlloc(big.NewInt(42220))
+	seeded := openings(t, database)[common.ZeroAddress]
+	require.NotEmpty(t, seeded)
+	for account, value := range seeded {
+		require.Equal(t, alloc[account].Balance.String(), value)
+	}
+
+	records := map[string][]*types.TokenRecord{
+		"tx_20200501": {{From: alice, To: bob, CoinID: types.CELO_COINID, Value: big.NewInt(1)}},
+	}
+	require.NoError(t, s.Seed(context.Background(), records))
+	require.Zero(t, node.Calls("eth_getBalance"))
+	require.Nil(t, genesisAlloc(big.NewInt(1337)))
+}
@@ -0,0 +1,155 @@
+package reorg
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/db"
+	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+const (
+	// maxDepth is the deepest reorg that is rolled back automatically.
+	maxDepth = 10000
+
+	// keepBlocks is how many recorded blocks below the newest are kept.
+	keepBlocks = 10000
+)
+
+// Error is returned when a block does not extend the recorded chain. The
+// records above Ancestor have been rolled back and indexing has to resume
+// at Ancestor+1.
+type Error struct {
+	Ancestor uint64
+	Depth    uint64
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("reorg of depth %d, common ancestor %d", e.Depth, e.Ancestor)
+}
+
+// Detector records the blocks processed by one indexer together with its
+// records and checkpoint, and checks that new blocks extend them.
+type Detector struct {
+	cli          *client.Client
+	database     *db.PostgresDB
+	indexer      string
+	recordPrefix string
+	maxDepth     uint64 // lowered by tests
+}
+
+// New returns a detector for indexer whose records live in the daily tables
+// named recordPrefix+YYYYMMDD.
+func New(cli *client.Client, database *db.PostgresDB, indexer, recordPrefix string) (*Detector, error) {
+	if err := database.CreateBlockTable(); err != nil {
+		return nil, err
+	}
+	if err := database.CreateCheckpointTable(); err != nil {
+		return nil, err
+	}
+	return &Detector{
+		cli:          cli,
+		database:     database,
+		indexer:      indexer,
+		recordPrefix: recordPrefix,
+		maxDepth:     maxDepth,
+	}, nil
+}
+
+// Checkpoint returns the height up to which the indexer has stored all
+// blocks. The boolean is false when it has not stored any yet.
+func (d *Detector) Checkpoint() (uint64, bool, error) {
+	return d.database.GetCheckpoint(d.indexer)
+}
+
+// Commit stores the records, keyed by table name, and the opening positions
+// of the accounts first recorded, records headers as indexed and moves the
+// checkpoint to height, all in one database transaction.
+func (d *Detector) Commit(height uint64, records map[string][]*ctypes.TokenRecord, positions []*ctypes.Position, headers ...*types.Header) error {
+	refs := make([]*ctypes.BlockRef, len(headers))
+	for i, h := range headers {
+		refs[i] = &ctypes.BlockRef{
+			Number:     h.Number.Uint64(),
+			Hash:       h.Hash(),
+			ParentHash: h.ParentHash,
+		}
+	}
+	if err := d.database.Commit(d.indexer, height, records, positions, refs); err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+
+	last := refs[len(refs)-1].Number
+	if last > keepBlocks && last/1000 != (last-uint64(len(refs)))/1000 {
+		return d.database.PruneBlocks(d.indexer, last-keepBlocks)
+	}
+	return nil
+}
+
+// Check verifies that header extends the recorded chain. On a mismatch it
+// finds the common ancestor with the canonical chain, deletes the records
+// and recorded blocks above it, moves the checkpoint back to it and returns
+// an *Error.
+func (d *Detector) Check(ctx context.Context, header *types.Header) error {
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil
+	}
+	stored, ok, err := d.database.GetBlockHash(d.indexer, number-1)
+	if err != nil {
+		return err
+	}
+	if !ok || stored == header.ParentHash {
+		return nil
+	}
+
+	ancestor, err := d.findAncestor(ctx, number-1)
+	if err != nil {
+		return err
+	}
+	depth := number - 1 - ancestor.Number.Uint64()
+	log.Warnf("%s indexer: reorg detected at block %d, depth %d, common ancestor %d (%v)",
+		d.indexer, number, depth, ancestor.Number, ancestor.Hash())
+
+	if err := d.rollback(ancestor); err != nil {
+		return err
+	}
+	return &Error{Ancestor: ancestor.Number.Uint64(), Depth: depth}
+}
+
+// findAncestor walks back from number to the newest recorded block that is
+// still canonical.
+func (d *Detector) findAncestor(ctx context.Context, number uint64) (*types.Header, error) {
+	for n := number; ; n-- {
+		if number-n > d.maxDepth {
+			return nil, fmt.Errorf("reorg deeper than %d blocks below %d", d.maxDepth, number)
+		}
+		canonical, err := d.cli.HeaderByNumber(ctx, new(big.Int).SetUint64(n))
+		if err != nil {
+			return nil, err
+		}
+		stored, ok, err := d.database.GetBlockHash(d.indexer, n)
+		if err != nil {
+			return nil, err
+		}
+		// Nothing recorded further back to compare against, assume the
+		// reorg does not reach beyond the recorded blocks.
+		if !ok || stored == canonical.Hash() || n == 0 {
+			return canonical, nil
+		}
+	}
+}
+
+// rollback deletes the records and recorded blocks above ancestor and moves
+// the checkpoint back to it.
+func (d *Detector) rollback(ancestor *types.Header) error {
+	t := time.Unix(int64(ancestor.Time), 0)
+	date := fmt.Sprintf("%04d%02d%02d", t.Year(), int(t.Month()), t.Day())
+	return d.database.Rollback(d.indexer, d.recordPrefix, date, ancestor.Number.Uint64())
+}
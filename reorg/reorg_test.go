@@ -0,0 +1,143 @@
+package reorg
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/client/mocknode"
+	"github.com/xuxinlai2002/creda-celo-balance/config"
+	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/db/dbtest"
+	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+// 2020-05-01 12:00:00 UTC.
+const day = 1588334400
+
+var alice = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+
+// indexed returns a node with blocks 1 to 5 and a detector that has committed
+// them with one record each.
+func indexed(t *testing.T) (*mocknode.Node, *Detector, *db.PostgresDB) {
+	node := mocknode.New(day)
+	t.Cleanup(node.Close)
+	cli, err := client.DialConfig(&config.Config{HTTP: node.URL})
+	require.NoError(t, err)
+	t.Cleanup(cli.Close)
+	database, err := db.NewDB("creda", "", "", dbtest.Use(t), 5432)
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	d, err := New(cli, database, "tx", "tx_")
+	require.NoError(t, err)
+
+	headers := make([]*types.Header, 0)
+	records := make(map[string][]*ctypes.TokenRecord)
+	for n := uint64(1); n <= 5; n++ {
+		header := node.AddBlock(day + n)
+		headers = append(headers, header)
+		table := "tx_" + time.Unix(int64(header.Time), 0).Format("20060102")
+		records[table] = append(records[table], &ctypes.TokenRecord{
+			CoinID:      ctypes.CELO_COINID,
+			BlockNumber: n,
+			Timestamp:   header.Time,
+			TxHash:      header.Hash(),
+			To:          alice,
+			Value:       big.NewInt(1),
+			LogIndex:    -1,
+		})
+	}
+	require.NoError(t, d.Commit(5, records, nil, headers...))
+	return node, d, database
+}
+
+// fork replaces the blocks above number with count new ones and returns the
+// header of the last.
+func fork(node *mocknode.Node, number uint64, count int) *types.Header {
+	node.Rewind(number)
+	var header *types.Header
+	for i := 0; i < count; i++ {
+		header = node.AddBlock(day + number + uint64(i) + 1)
+	}
+	return header
+}
+
+// requireIndexed checks that the records, recorded blocks and checkpoint end
+// at block number.
+func requireIndexed(t *testing.T, node *mocknode.Node, database *db.PostgresDB, number uint64) {
+	records, err := database.ReadPullTxHistory(time.Unix(day, 0))
+	require.NoError(t, err)
+	require.Len(t, records, int(number))
+	for _, r := range records {
+		require.LessOrEqual(t, r.BlockNumber, number)
+	}
+	height, _, err := database.GetCheckpoint("tx")
+	require.NoError(t, err)
+	require.Equal(t, number, height)
+	hash, ok, err := database.GetBlockHash("tx", number)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, node.Header(number).Hash(), hash)
+	_, ok, err = database.GetBlockHash("tx", number+1)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+// TestCheckExtends tests that a block extending the recorded chain passes.
+func TestCheckExtends(t *testing.T) {
+	node, d, database := indexed(t)
+	require.NoError(t, d.Check(context.Background(), node.AddBlock(day+6)))
+	requireIndexed(t, node, database, 5)
+}
+
+// TestCheckReorg tests that one-block and multi-block reorgs are rolled back
+// to their common ancestor, keeping what is at or below it.
+func TestCheckReorg(t *testing.T) {
+	for _, tc := range []struct {
+		ancestor uint64
+		depth    uint64
+	}{
+		{ancestor: 4, depth: 1},
+		{ancestor: 1, depth: 4},
+	} {
+		node, d, database := indexed(t)
+		head := fork(node, tc.ancestor, int(6-tc.ancestor))
+
+		err := d.Check(context.Background(), head)
+		var reorgErr *Error
+		require.True(t, errors.As(err, &reorgErr), "%v", err)
+		require.Equal(t, &Error{Ancestor: tc.ancestor, Depth: tc.depth}, reorgErr)
+		requireIndexed(t, node, database, tc.ancestor)
+	}
+}
+
+// TestCheckTooDeep tests that a reorg deeper than maxDepth is reported
+// without rolling anything back.
+func TestCheckTooDeep(t *testing.T) {
+	node, d, database := indexed(t)
+	d.maxDepth = 3
+	head := fork(node, 1, 5)
+
+	err := d.Check(context.Background(), head)
+	require.ErrorContains(t, err, "reorg deeper than 3 blocks below 5")
+	var reorgErr *Error
+	require.False(t, errors.As(err, &reorgErr))
+
+	records, err := database.ReadPullTxHistory(time.Unix(day, 0))
+	require.NoError(t, err)
+	require.Len(t, records, 5)
+	height, _, err := database.GetCheckpoint("tx")
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), height)
+
+	// A reorg as deep as maxDepth is still rolled back.
+	d.maxDepth = 4
+	require.True(t, errors.As(d.Check(context.Background(), head), &reorgErr))
+	require.Equal(t, &Error{Ancestor: 1, Depth: 4}, reorgErr)
+}
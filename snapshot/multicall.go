@@ -0,0 +1,106 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/celo-org/celo-blockchain/accounts/abi"
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/common/hexutil"
+	"github.com/celo-org/celo-blockchain/crypto"
+)
+
+// multicallABI is the aggregate3 function of Multicall3.
+const multicallABI = `[{"inputs":[{"components":[` +
+	`{"internalType":"address","name":"target","type":"address"},` +
+	`{"internalType":"bool","name":"allowFailure","type":"bool"},` +
+	`{"internalType":"bytes","name":"callData","type":"bytes"}],` +
+	`"internalType":"struct Multicall3.Call3[]","name":"calls","type":"tuple[]"}],` +
+	`"name":"aggregate3","outputs":[{"components":[` +
+	`{"internalType":"bool","name":"success","type":"bool"},` +
+	`{"internalType":"bytes","name":"returnData","type":"bytes"}],` +
+	`"internalType":"struct Multicall3.Result[]","name":"returnData","type":"tuple[]"}],` +
+	`"stateMutability":"payable","type":"function"}]`
+
+var multicall = mustParseABI(multicallABI)
+
+// balanceOfSelector is the selector of the ERC20 balanceOf(address).
+var balanceOfSelector = crypto.Keccak256([]byte("balanceOf(address)"))[:4]
+
+// errNoMulticall is returned when the Multicall contract has no code at the
+// block, e.g. because it was deployed later.
+var errNoMulticall = errors.New("multicall contract not deployed")
+
+func mustParseABI(def string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(def))
+	if err != nil {
+		panic(any(err.Error()))
+	}
+	return parsed
+}
+
+// call3 is a call of aggregate3.
+type call3 struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+// result3 is the outcome of a call of aggregate3.
+type result3 struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// balanceOfCall returns the call of balanceOf(account) of token. Failures are
+// allowed so that a single broken token does not fail its whole batch.
+func balanceOfCall(token, account common.Address) call3 {
+	data := append(append([]byte{}, balanceOfSelector...), common.LeftPadBytes(account.Bytes(), 32)...)
+	return call3{Target: token, AllowFailure: true, CallData: data}
+}
+
+// decodeBalance decodes the return value of balanceOf, zero while the token
+// has no code yet.
+func decodeBalance(r result3) (*big.Int, error) {
+	if !r.Success {
+		return nil, errors.New("balanceOf reverted")
+	}
+	switch len(r.ReturnData) {
+	case 0:
+		return big.NewInt(0), nil
+	case 32:
+		return new(big.Int).SetBytes(r.ReturnData), nil
+	}
+	return nil, fmt.Errorf("balanceOf returned %d bytes", len(r.ReturnData))
+}
+
+// aggregate runs calls in one eth_call of aggregate3 of the Multicall
+// contract at block number and returns their results in order.
+func (s *Snapshotter) aggregate(ctx context.Context, calls []call3, number *big.Int) ([]result3, error) {
+	input, err := multicall.Pack("aggregate3", calls)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := s.cli.CallContract(ctx, map[string]interface{}{
+		"to":   s.multicall,
+		"data": hexutil.Bytes(input),
+	}, number)
+	if err != nil {
+		return nil, err
+	}
+	if len(ret) == 0 {
+		return nil, errNoMulticall
+	}
+	out, err := multicall.Unpack("aggregate3", ret)
+	if err != nil {
+		return nil, err
+	}
+	results := *abi.ConvertType(out[0], new([]result3)).(*[]result3)
+	if len(results) != len(calls) {
+		return nil, fmt.Errorf("aggregate3 returned %d results for %d calls", len(results), len(calls))
+	}
+	return results, nil
+}
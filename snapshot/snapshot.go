@@ -0,0 +1,258 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/config"
+	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/metadata"
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+// Snapshotter reads the balances of many accounts at one block from the
+// chain, to verify rebuilt balances or to produce airdrop and credit
+// snapshots without replaying history.
+type Snapshotter struct {
+	cfg *config.Config
+	cli *client.Client
+	db  *db.PostgresDB
+
+	// tokens are the tracked tokens ordered by address. GoldToken is left
+	// out, its balances are the native CELO balances.
+	tokens    []*types.Token
+	multicall common.Address
+	batchSize int
+}
+
+func New(cfg *config.Config) (*Snapshotter, error) {
+	cli, err := client.DialConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	database, err := db.NewDB(cfg.PostgresDBName, cfg.PostgresUser, cfg.PostgresPassword, cfg.PostgresHost, cfg.PostgresPort)
+	if err != nil {
+		cli.Close()
+		return nil, errors.New(fmt.Sprintf("new db err: %v", err))
+	}
+	s, err := NewWith(cfg, cli, database)
+	if err != nil {
+		database.Close()
+		cli.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewWith returns a snapshotter reading through cli and storing in database,
+// which stay owned by the caller.
+func NewWith(cfg *config.Config, cli *client.Client, database *db.PostgresDB) (*Snapshotter, error) {
+	if err := database.CreateSnapshotTable(); err != nil {
+		return nil, err
+	}
+	registry, err := metadata.Load(cfg.TokenRegistry)
+	if err != nil {
+		return nil, err
+	}
+	return newSnapshotter(cfg, cli, database, registry), nil
+}
+
+func newSnapshotter(cfg *config.Config, cli *client.Client, database *db.PostgresDB, registry map[common.Address]*types.Token) *Snapshotter {
+	tokens := make([]*types.Token, 0, len(registry))
+	for _, token := range registry {
+		if token.CoinID != types.CELO_COINID {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return bytes.Compare(tokens[i].Address[:], tokens[j].Address[:]) < 0 })
+	return &Snapshotter{
+		cfg:       cfg,
+		cli:       cli,
+		db:        database,
+		tokens:    tokens,
+		multicall: common.HexToAddress(cfg.Multicall),
+		batchSize: cfg.MulticallBatchSize,
+	}
+}
+
+// Run takes the snapshot at SnapshotBlock of the accounts listed in
+// SnapshotAddresses and stores it. The connections are closed when it
+// returns.
+func (s *Snapshotter) Run(ctx context.Context) error {
+	defer s.Close()
+	accounts, err := s.accounts()
+	if err != nil {
+		return err
+	}
+	balances, err := s.Take(ctx, s.cfg.SnapshotBlock, accounts)
+	if err != nil {
+		return err
+	}
+	log.Infof("snapshot at block %v: %v balances of %v accounts in %v tokens",
+		s.cfg.SnapshotBlock, len(balances), len(accounts), len(s.tokens)+1)
+	return s.db.InsertSnapshot(balances)
+}
+
+// Close releases the connections.
+func (s *Snapshotter) Close() {
+	s.db.Close()
+	s.cli.Close()
+}
+
+// accounts returns the accounts listed in SnapshotAddresses, one per line,
+// or every account seen in the records when it is unset.
+func (s *Snapshotter) accounts() ([]common.Address, error) {
+	if s.cfg.SnapshotAddresses == "" {
+		seen := make(map[common.Address]bool)
+		for _, prefix := range []string{"tx_", "event"} {
+			addresses, err := s.db.ReadAddresses(prefix)
+			if err != nil {
+				return nil, err
+			}
+			for _, accounts := range addresses {
+				for account := range accounts {
+					seen[account] = true
+				}
+			}
+		}
+		accounts := make([]common.Address, 0, len(seen))
+		for account := range seen {
+			accounts = append(accounts, account)
+		}
+		sort.Slice(accounts, func(i, j int) bool { return bytes.Compare(accounts[i][:], accounts[j][:]) < 0 })
+		return accounts, nil
+	}
+	return readAccounts(s.cfg.SnapshotAddresses)
+}
+
+// readAccounts reads the accounts listed in the file at path, one per line,
+// skipping blank lines and duplicates.
+func readAccounts(path string) ([]common.Address, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	seen := make(map[common.Address]bool)
+	accounts := make([]common.Address, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !common.IsHexAddress(line) {
+			return nil, errors.New(fmt.Sprintf("%s: invalid address %q", path, line))
+		}
+		account := common.HexToAddress(line)
+		if !seen[account] {
+			seen[account] = true
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts, scanner.Err()
+}
+
+// Take reads the native CELO and token balances of accounts at block number.
+// Native balances come from batched eth_getBalance calls, token balances
+// from balanceOf calls batched through the Multicall contract. Zero balances
+// are left out.
+func (s *Snapshotter) Take(ctx context.Context, number uint64, accounts []common.Address) ([]*types.Balance, error) {
+	block := new(big.Int).SetUint64(number)
+	balances := make([]*types.Balance, 0)
+	add := func(token common.Address, coinID uint64, account common.Address, value *big.Int) {
+		if value.Sign() != 0 {
+			balances = append(balances, &types.Balance{
+				BlockNumber: number,
+				Address:     account,
+				Token:       token,
+				CoinID:      coinID,
+				Value:       value,
+			})
+		}
+	}
+
+	native, errs := s.cli.BalancesAt(ctx, accounts, block)
+	for i, account := range accounts {
+		if errs[i] != nil {
+			return nil, errors.New(fmt.Sprintf("balance of %v at %v err: %v", account, number, errs[i]))
+		}
+		add(common.ZeroAddress, types.CELO_COINID, account, native[i])
+	}
+
+	tokenBalances, err := s.tokenBalances(ctx, accounts, block)
+	if err != nil {
+		return nil, err
+	}
+	for i, token := range s.tokens {
+		for j, account := range accounts {
+			add(token.Address, token.CoinID, account, tokenBalances[i][j])
+		}
+	}
+	return balances, nil
+}
+
+// tokenBalances reads the balances of accounts in every token, indexed by
+// token and account, batchSize balanceOf calls per eth_call of the Multicall
+// contract. Before the Multicall contract is deployed it falls back to
+// batched eth_call of every balanceOf.
+func (s *Snapshotter) tokenBalances(ctx context.Context, accounts []common.Address, block *big.Int) ([][]*big.Int, error) {
+	balances := make([][]*big.Int, len(s.tokens))
+	for i := range s.tokens {
+		balances[i] = make([]*big.Int, len(accounts))
+	}
+	total := len(s.tokens) * len(accounts)
+	for start := 0; start < total; start += s.batchSize {
+		end := start + s.batchSize
+		if end > total {
+			end = total
+		}
+		calls := make([]call3, 0, end-start)
+		for k := start; k < end; k++ {
+			calls = append(calls, balanceOfCall(s.tokens[k/len(accounts)].Address, accounts[k%len(accounts)]))
+		}
+		results, err := s.aggregate(ctx, calls, block)
+		if err == errNoMulticall {
+			log.Warnf("multicall %v has no code at block %v, calling balanceOf directly", s.multicall, block)
+			return s.directTokenBalances(ctx, accounts, block)
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("multicall at %v err: %v", block, err))
+		}
+		for k := start; k < end; k++ {
+			token, account := s.tokens[k/len(accounts)], accounts[k%len(accounts)]
+			balance, err := decodeBalance(results[k-start])
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("balance of %v in %s at %v err: %v", account, token.Name, block, err))
+			}
+			balances[k/len(accounts)][k%len(accounts)] = balance
+		}
+	}
+	return balances, nil
+}
+
+// directTokenBalances reads the balances of accounts in every token like
+// tokenBalances, with one batched eth_call per balanceOf.
+func (s *Snapshotter) directTokenBalances(ctx context.Context, accounts []common.Address, block *big.Int) ([][]*big.Int, error) {
+	balances := make([][]*big.Int, len(s.tokens))
+	for i, token := range s.tokens {
+		var errs []error
+		balances[i], errs = s.cli.TokenBalancesAt(ctx, token.Address, accounts, block)
+		for j, account := range accounts {
+			if errs[j] != nil {
+				return nil, errors.New(fmt.Sprintf("balance of %v in %s at %v err: %v", account, token.Name, block, errs[j]))
+			}
+		}
+	}
+	return balances, nil
+}
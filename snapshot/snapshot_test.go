@@ -0,0 +1,99 @@
+package snapshot
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/client/mocknode"
+	"github.com/xuxinlai2002/creda-celo-balance/config"
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+var (
+	alice = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	bob   = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+	carol = common.HexToAddress("0x00000000000000000000000000000000000ca201")
+
+	cUSD      = common.HexToAddress("0x765DE816845861e75A25fCA122bb6898B8B1282a")
+	cEUR      = common.HexToAddress("0xD8763CBa276a3738E6DE85b4b3bF5FDed6D6cA73")
+	goldToken = common.HexToAddress("0x471EcE3750Da237f93B8E339c536989b8978a438")
+)
+
+// TestTake tests that a snapshot reads native balances and the balances of
+// every token through the Multicall contract, in batches, and falls back to
+// direct balanceOf calls before the contract is deployed.
+func TestTake(t *testing.T) {
+	multicall := common.HexToAddress("0xcA11bde05977b3631167028862bE2a173976CA11")
+	node := mocknode.New(1000)
+	defer node.Close()
+	node.AddBlock(1005)
+	node.AddBlock(1010)
+	node.SetMulticall(multicall, 2)
+	node.SetBalance(alice, 0, big.NewInt(7))
+	node.SetBalance(carol, 0, big.NewInt(1))
+	node.SetTokenBalance(cUSD, alice, big.NewInt(100))
+	node.SetTokenBalance(cUSD, bob, big.NewInt(20))
+	node.SetTokenBalance(cEUR, carol, big.NewInt(3))
+
+	cli, err := client.Dial(node.URL)
+	require.NoError(t, err)
+	defer cli.Close()
+	cfg := &config.Config{Multicall: multicall.Hex(), MulticallBatchSize: 4}
+	s := newSnapshotter(cfg, cli, nil, map[common.Address]*types.Token{
+		cUSD:      {Address: cUSD, Name: "cUSD", CoinID: 7236},
+		cEUR:      {Address: cEUR, Name: "cEUR", CoinID: 7237},
+		goldToken: {Address: goldToken, Name: "CELO", CoinID: types.CELO_COINID},
+	})
+
+	type balance struct {
+		account, token common.Address
+		coinID         uint64
+		value          int64
+	}
+	want := []balance{
+		{alice, common.ZeroAddress, types.CELO_COINID, 7},
+		{carol, common.ZeroAddress, types.CELO_COINID, 1},
+		{alice, cUSD, 7236, 100},
+		{bob, cUSD, 7236, 20},
+		{carol, cEUR, 7237, 3},
+	}
+	accounts := []common.Address{alice, bob, carol}
+	for _, number := range []uint64{2, 1} {
+		calls := node.Calls("eth_call")
+		balances, err := s.Take(context.Background(), number, accounts)
+		require.NoError(t, err)
+		var got []balance
+		for _, b := range balances {
+			require.Equal(t, number, b.BlockNumber)
+			got = append(got, balance{b.Address, b.Token, b.CoinID, b.Value.Int64()})
+		}
+		require.Equal(t, want, got)
+		if number == 2 {
+			// 2 tokens of 3 accounts in batches of 4 calls.
+			require.Equal(t, 2, node.Calls("eth_call")-calls)
+		} else {
+			// The failed multicall and one call per balance.
+			require.Equal(t, 1+6, node.Calls("eth_call")-calls)
+		}
+	}
+}
+
+// TestReadAccounts tests that account files may hold blank lines and
+// duplicates but no malformed addresses.
+func TestReadAccounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+	require.NoError(t, os.WriteFile(path, []byte(alice.Hex()+"\n\n "+bob.Hex()+"\n"+alice.Hex()+"\n"), 0644))
+	accounts, err := readAccounts(path)
+	require.NoError(t, err)
+	require.Equal(t, []common.Address{alice, bob}, accounts)
+
+	require.NoError(t, os.WriteFile(path, []byte("0x1234\n"), 0644))
+	_, err = readAccounts(path)
+	require.Error(t, err)
+}
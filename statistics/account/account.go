@@ -2,30 +2,31 @@ package account
 
 import (
 	"bufio"
-	"context"
 	"errors"
 	"fmt"
 	"math/big"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/celo-org/celo-blockchain/common"
-	"github.com/xuxinlai2002/creda-celo-balance/client"
 	"github.com/xuxinlai2002/creda-celo-balance/config"
 	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/metadata"
 	"github.com/xuxinlai2002/creda-celo-balance/types"
 )
 
 var zeroAddress = common.ZeroAddress.String()
 
 type Account struct {
-	cfg    *config.Config
-	db     *db.PostgresDB
-	client *client.Client
+	cfg *config.Config
+	db  *db.PostgresDB
 
 	accounts         map[types.ADDRESS]map[types.COINID]*big.Int
+	positions        map[types.ADDRESS]*types.LockedGold
 	coinPriceHistory map[types.COINID]map[types.DATE]*big.Float
+	decimals         map[types.COINID]uint8
 
 	wg *sync.WaitGroup
 }
@@ -44,12 +45,10 @@ func New(cfg *config.Config, wg *sync.WaitGroup) (*Account, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	cli, err := client.Dial(cfg.HTTP)
+	acc.decimals, err = metadata.LoadDecimals(database)
 	if err != nil {
 		return nil, err
 	}
-	acc.client = cli
 	return acc, nil
 }
 
@@ -116,6 +115,10 @@ func (a *Account) statisticsBalance() error {
 		return errors.New("StatisticsDateEnd time format error " + err.Error())
 	}
 	a.accounts = make(map[types.ADDRESS]map[types.COINID]*big.Int)
+	a.positions = make(map[types.ADDRESS]*types.LockedGold)
+	if err := a.applyOpenings(); err != nil {
+		return errors.New("read opening balances err: " + err.Error())
+	}
 	for i := startDate; i.Before(endDate); i = i.AddDate(0, 0, 1) {
 		fmt.Println("read date", i.String())
 		pullTxRecords, _ := a.db.ReadPullTxHistory(i)
@@ -126,9 +129,20 @@ func (a *Account) statisticsBalance() error {
 		if err != nil {
 			tokenRecords = make([]*types.TokenRecord, 0)
 		}
+		tokenRecords, dropped := reconcileCELO(pullTxRecords, tokenRecords)
+		if dropped > 0 {
+			fmt.Println("dropped GoldToken transfers already traced", "count", dropped)
+		}
 		for _, r := range pullTxRecords {
+			if r.FrameType == types.LOCKED_GOLD_FRAME {
+				a.calcLockedGold(r)
+				continue
+			}
 			a.calcAccountBalance(r)
 		}
+		if err := a.settleLockedGold(); err != nil {
+			return errors.New("settle LockedGold of " + i.Format(layout) + " err: " + err.Error())
+		}
 		for _, r := range tokenRecords {
 			a.calcAccountBalance(r)
 		}
@@ -140,6 +154,35 @@ func (a *Account) statisticsBalance() error {
 	return nil
 }
 
+// applyOpenings opens the accounts with the balances and LockedGold
+// positions they held before the indexers started, whatever day the run
+// begins with.
+func (a *Account) applyOpenings() error {
+	positions, err := a.db.ReadOpeningPositions()
+	if err != nil {
+		return err
+	}
+	for _, p := range positions {
+		a.positions[types.ADDRESS(p.Address.String())] = &types.LockedGold{Locked: p.Locked, Pending: p.Pending}
+	}
+	openings, err := a.db.ReadOpenings()
+	if err != nil {
+		return err
+	}
+	for _, b := range openings {
+		account := types.ADDRESS(b.Address.String())
+		coinID := types.COINID(b.CoinID)
+		if _, exists := a.accounts[account]; !exists {
+			a.accounts[account] = make(map[types.COINID]*big.Int)
+		}
+		if a.accounts[account][coinID] == nil {
+			a.accounts[account][coinID] = big.NewInt(0)
+		}
+		a.accounts[account][coinID].Add(a.accounts[account][coinID], b.Value)
+	}
+	return nil
+}
+
 func (a *Account) calcUSDValue(date time.Time) error {
 	dateStr := date.Format("2006-01-02")
 	tableName := "ods_balance_" + date.Format("20060102")
@@ -147,8 +190,17 @@ func (a *Account) calcUSDValue(date time.Time) error {
 	if err != nil {
 		return err
 	}
-	err = a.db.InsertAccountHistoryBalance(tableName, types.DATE(dateStr), a.accounts, a.coinPriceHistory)
-	return err
+	err = a.db.InsertAccountHistoryBalance(tableName, types.DATE(dateStr), a.accounts, a.positions, a.coinPriceHistory, a.decimals)
+	if err != nil {
+		return err
+	}
+
+	// The balances per coin are kept for the on-chain audit.
+	coinTableName := "ods_coin_balance_" + date.Format("20060102")
+	if err := a.db.CreateCoinBalanceTable(coinTableName); err != nil {
+		return err
+	}
+	return a.db.InsertAccountCoinBalance(coinTableName, types.DATE(dateStr), a.accounts)
 }
 
 func (a *Account) calcAccountBalance(record *types.TokenRecord) {
@@ -166,13 +218,6 @@ func (a *Account) calcAccountBalance(record *types.TokenRecord) {
 			a.accounts[from][coinID] = balance
 		}
 		a.accounts[from][coinID] = balance.Sub(balance, intValue)
-		if a.accounts[from][coinID].Sign() < 0 && coinID == types.CELO_COINID {
-			b, err := a.client.BalanceAt(context.Background(), record.From, big.NewInt(0).SetUint64(record.BlockNumber))
-			if err != nil {
-				panic("balance at error " + err.Error())
-			}
-			a.accounts[from][coinID] = b
-		}
 	}
 
 	if string(to) != zeroAddress {
@@ -187,3 +232,54 @@ func (a *Account) calcAccountBalance(record *types.TokenRecord) {
 		a.accounts[to][coinID] = balance.Add(balance, intValue)
 	}
 }
+
+// calcLockedGold applies a LockedGold event, or the epoch rewards of a
+// voter, to the position of its account.
+// Locking and withdrawing also move liquid CELO, which the traced transfers
+// of the same transaction account for.
+func (a *Account) calcLockedGold(record *types.TokenRecord) {
+	account := types.ADDRESS(record.From.String())
+	position := a.positions[account]
+	if position == nil {
+		position = types.NewLockedGold()
+		a.positions[account] = position
+	}
+	name := record.TraceAddress
+	if strings.HasPrefix(name, types.GOLD_REWARDED+".") {
+		name = types.GOLD_REWARDED
+	}
+	switch name {
+	case types.GOLD_LOCKED, types.GOLD_REWARDED:
+		position.Locked.Add(position.Locked, record.Value)
+	case types.GOLD_UNLOCKED:
+		position.Locked.Sub(position.Locked, record.Value)
+		position.Pending.Add(position.Pending, record.Value)
+	case types.GOLD_RELOCKED:
+		position.Pending.Sub(position.Pending, record.Value)
+		position.Locked.Add(position.Locked, record.Value)
+	case types.GOLD_WITHDRAWN:
+		position.Pending.Sub(position.Pending, record.Value)
+	case types.GOLD_SLASHED:
+		position.Locked.Sub(position.Locked, record.Value)
+	case types.GOLD_SLASH_REWARDED:
+		position.Locked.Add(position.Locked, record.Value)
+	default:
+		fmt.Println("unknown LockedGold event", "name", record.TraceAddress, "tx", record.TxHash)
+	}
+}
+
+// settleLockedGold drops empty positions. Positions start from the opening
+// ones, so a position gone negative does not match the chain and fails the
+// run instead of being valued.
+func (a *Account) settleLockedGold() error {
+	for account, position := range a.positions {
+		if position.Locked.Sign() < 0 || position.Pending.Sign() < 0 {
+			return errors.New(fmt.Sprintf("LockedGold position of %v went negative, locked %v pending %v",
+				account, position.Locked, position.Pending))
+		}
+		if position.Locked.Sign() == 0 && position.Pending.Sign() == 0 {
+			delete(a.positions, account)
+		}
+	}
+	return nil
+}
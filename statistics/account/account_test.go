@@ -0,0 +1,197 @@
+package account
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/config"
+	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/db/dbtest"
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+var (
+	alice = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	bob   = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+	carol = common.HexToAddress("0x00000000000000000000000000000000000ca201")
+)
+
+const cUSDCoinID = 7236
+
+// TestCalcAccountBalance tests that transfers move balances between accounts
+// on top of their opening balances.
+func TestCalcAccountBalance(t *testing.T) {
+	a := &Account{
+		accounts: map[types.ADDRESS]map[types.COINID]*big.Int{
+			types.ADDRESS(alice.String()): {types.CELO_COINID: big.NewInt(1000)},
+		},
+	}
+
+	for _, r := range []*types.TokenRecord{
+		{From: common.ZeroAddress, To: alice, Value: big.NewInt(50), CoinID: cUSDCoinID, BlockNumber: 1},
+		{From: alice, To: bob, Value: big.NewInt(20), CoinID: cUSDCoinID, BlockNumber: 1},
+		{From: alice, To: bob, Value: big.NewInt(300), CoinID: types.CELO_COINID, BlockNumber: 1},
+		{From: bob, To: carol, Value: big.NewInt(30), CoinID: cUSDCoinID, BlockNumber: 1},
+	} {
+		a.calcAccountBalance(r)
+	}
+
+	require.Len(t, a.accounts, 3)
+	require.Equal(t, big.NewInt(30), a.accounts[types.ADDRESS(alice.String())][cUSDCoinID])
+	require.Equal(t, big.NewInt(700), a.accounts[types.ADDRESS(alice.String())][types.CELO_COINID])
+	require.Equal(t, big.NewInt(300), a.accounts[types.ADDRESS(bob.String())][types.CELO_COINID])
+	// Without an opening balance a missing record shows as a negative
+	// balance instead of being papered over.
+	require.Equal(t, big.NewInt(-10), a.accounts[types.ADDRESS(bob.String())][cUSDCoinID])
+	require.Equal(t, big.NewInt(30), a.accounts[types.ADDRESS(carol.String())][cUSDCoinID])
+}
+
+// TestReconcileCELO tests that a GoldToken Transfer event is dropped once for
+// each traced native movement it duplicates.
+func TestReconcileCELO(t *testing.T) {
+	tx1, tx2 := common.HexToHash("0x01"), common.HexToHash("0x02")
+	traced := []*types.TokenRecord{
+		{TxHash: tx1, From: alice, To: bob, Value: big.NewInt(5), CoinID: types.CELO_COINID, LogIndex: -1},
+		{TxHash: tx2, From: alice, To: bob, Value: big.NewInt(5), CoinID: types.CELO_COINID, LogIndex: -1, TraceAddress: "0"},
+	}
+	events := []*types.TokenRecord{
+		// Duplicates of the traced movements, the second one twice.
+		{TxHash: tx1, From: alice, To: bob, Value: big.NewInt(5), CoinID: types.CELO_COINID, LogIndex: 0},
+		{TxHash: tx2, From: alice, To: bob, Value: big.NewInt(5), CoinID: types.CELO_COINID, LogIndex: 1},
+		{TxHash: tx2, From: alice, To: bob, Value: big.NewInt(5), CoinID: types.CELO_COINID, LogIndex: 2},
+		// Different amount, parties or coin.
+		{TxHash: tx1, From: alice, To: bob, Value: big.NewInt(6), CoinID: types.CELO_COINID, LogIndex: 3},
+		{TxHash: tx1, From: bob, To: alice, Value: big.NewInt(5), CoinID: types.CELO_COINID, LogIndex: 4},
+		{TxHash: tx1, From: alice, To: bob, Value: big.NewInt(5), CoinID: cUSDCoinID, LogIndex: 5},
+	}
+
+	kept, dropped := reconcileCELO(traced, events)
+	require.Equal(t, 2, dropped)
+	require.Equal(t, events[2:], kept)
+
+	kept, dropped = reconcileCELO(nil, events)
+	require.Zero(t, dropped)
+	require.Equal(t, events, kept)
+}
+
+// TestLoadCoinPrice tests parsing of the price history file.
+func TestLoadCoinPrice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "price.txt")
+	require.NoError(t, os.WriteFile(path, []byte("5567 2020-05-01 2.5\n\n7236 2020-05-01 1\n"), 0644))
+
+	a := &Account{}
+	require.NoError(t, a.loadCoinPrice(path))
+	require.Equal(t, "2.5", a.coinPriceHistory[types.CELO_COINID]["2020-05-01"].String())
+	require.Equal(t, "1", a.coinPriceHistory[cUSDCoinID]["2020-05-01"].String())
+
+	require.NoError(t, os.WriteFile(path, []byte("CELO 2020-05-01 2.5\n"), 0644))
+	require.Error(t, a.loadCoinPrice(path))
+}
+
+// TestCalcLockedGold tests that LockedGold events move CELO between the
+// locked and pending positions of an account, voter rewards add to the
+// locked CELO, and that a position going
+// negative fails the run.
+func TestCalcLockedGold(t *testing.T) {
+	a := &Account{positions: map[types.ADDRESS]*types.LockedGold{
+		types.ADDRESS(bob.String()): {Locked: big.NewInt(7), Pending: big.NewInt(0)},
+	}}
+	for _, r := range []*types.TokenRecord{
+		{From: alice, To: alice, Value: big.NewInt(100), TraceAddress: types.GOLD_LOCKED},
+		{From: alice, To: alice, Value: big.NewInt(40), TraceAddress: types.GOLD_UNLOCKED},
+		{From: alice, To: alice, Value: big.NewInt(10), TraceAddress: types.GOLD_RELOCKED},
+		{From: alice, To: alice, Value: big.NewInt(20), TraceAddress: types.GOLD_WITHDRAWN},
+		{From: alice, To: alice, Value: big.NewInt(5), TraceAddress: types.GOLD_SLASHED},
+		{From: alice, To: alice, Value: big.NewInt(3), TraceAddress: types.GOLD_REWARDED + "." + alice.Hex()},
+		{From: bob, To: bob, Value: big.NewInt(2), TraceAddress: types.GOLD_SLASH_REWARDED},
+		{From: bob, To: bob, Value: big.NewInt(9), TraceAddress: types.GOLD_UNLOCKED},
+	} {
+		a.calcLockedGold(r)
+	}
+	require.NoError(t, a.settleLockedGold())
+
+	require.Len(t, a.positions, 2)
+	position := a.positions[types.ADDRESS(alice.String())]
+	require.Equal(t, "68", position.Locked.String())
+	require.Equal(t, "10", position.Pending.String())
+	position = a.positions[types.ADDRESS(bob.String())]
+	require.Equal(t, "0", position.Locked.String())
+	require.Equal(t, "9", position.Pending.String())
+
+	a.calcLockedGold(&types.TokenRecord{From: bob, To: bob, Value: big.NewInt(9), TraceAddress: types.GOLD_WITHDRAWN})
+	require.NoError(t, a.settleLockedGold())
+	require.NotContains(t, a.positions, types.ADDRESS(bob.String()))
+
+	// A withdrawal without an unlocked position is not on the chain.
+	a.calcLockedGold(&types.TokenRecord{From: bob, To: bob, Value: big.NewInt(1), TraceAddress: types.GOLD_WITHDRAWN})
+	require.EqualError(t, a.settleLockedGold(), "LockedGold position of "+bob.String()+" went negative, locked 0 pending -1")
+}
+
+// TestApplyOpenings tests that the opening balances are applied to the
+// accounts, those of tokens sharing a coin id added up, together with the
+// opening positions.
+func TestApplyOpenings(t *testing.T) {
+	database, err := db.NewDB("creda", "", "", dbtest.Use(t), 5432)
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.CreateOpeningTable())
+	cUSD := common.HexToAddress("0x765DE816845861e75A25fCA122bb6898B8B1282a")
+	bridged := common.HexToAddress("0x00000000000000000000000000000000000b71d9")
+	require.NoError(t, database.InsertOpenings([]*types.Balance{
+		{BlockNumber: 0, Address: alice, CoinID: types.CELO_COINID, Value: big.NewInt(1000)},
+		{BlockNumber: 4, Address: alice, Token: cUSD, CoinID: cUSDCoinID, Value: big.NewInt(20)},
+		{BlockNumber: 4, Address: alice, Token: bridged, CoinID: cUSDCoinID, Value: big.NewInt(5)},
+		{BlockNumber: 4, Address: bob, Token: cUSD, CoinID: cUSDCoinID, Value: big.NewInt(0)},
+	}))
+	// The first opening balance of an account in a token is kept.
+	require.NoError(t, database.InsertOpenings([]*types.Balance{
+		{BlockNumber: 9, Address: alice, CoinID: types.CELO_COINID, Value: big.NewInt(7)},
+	}))
+
+	require.NoError(t, database.InsertOpeningPositions([]*types.Position{
+		{BlockNumber: 4, Address: bob, Locked: big.NewInt(30), Pending: big.NewInt(6)},
+	}))
+
+	a := &Account{
+		db:        database,
+		accounts:  make(map[types.ADDRESS]map[types.COINID]*big.Int),
+		positions: make(map[types.ADDRESS]*types.LockedGold),
+	}
+	require.NoError(t, a.applyOpenings())
+	require.Len(t, a.positions, 1)
+	require.Equal(t, "30", a.positions[types.ADDRESS(bob.String())].Locked.String())
+	require.Equal(t, "6", a.positions[types.ADDRESS(bob.String())].Pending.String())
+	require.Len(t, a.accounts, 2)
+	require.Equal(t, "1000", a.accounts[types.ADDRESS(alice.String())][types.CELO_COINID].String())
+	require.Equal(t, "25", a.accounts[types.ADDRESS(alice.String())][cUSDCoinID].String())
+	require.Equal(t, "0", a.accounts[types.ADDRESS(bob.String())][cUSDCoinID].String())
+}
+
+// TestStatisticsBalanceNegativePosition tests that a run stops at the day a
+// LockedGold position goes negative.
+func TestStatisticsBalanceNegativePosition(t *testing.T) {
+	database, err := db.NewDB("creda", "", "", dbtest.Use(t), 5432)
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.CreateOpeningTable())
+	require.NoError(t, database.InsertOpeningPositions([]*types.Position{
+		{BlockNumber: 4, Address: bob, Locked: big.NewInt(30), Pending: big.NewInt(6)},
+	}))
+	require.NoError(t, database.CreateRecordTable("tx_20200501"))
+	require.NoError(t, database.InsertRecords("tx_20200501", []*types.TokenRecord{{
+		CoinID: types.CELO_COINID, BlockNumber: 5, Timestamp: 1588334405, TxHash: common.HexToHash("0x05"),
+		From: bob, To: bob, Value: big.NewInt(10), LogIndex: 0,
+		TraceAddress: types.GOLD_WITHDRAWN, FrameType: types.LOCKED_GOLD_FRAME,
+	}}))
+
+	a := &Account{
+		cfg: &config.Config{StatisticsDateBegin: "2020-05-01", StatisticsDateEnd: "2020-05-02"},
+		db:  database,
+	}
+	require.EqualError(t, a.statisticsBalance(), "settle LockedGold of 2020-05-01 err: LockedGold position of "+
+		bob.String()+" went negative, locked 30 pending -4")
+}
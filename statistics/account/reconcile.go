@@ -0,0 +1,48 @@
+package account
+
+import (
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+// movement identifies a CELO transfer by transaction, parties and amount.
+type movement struct {
+	txHash   common.Hash
+	from, to common.Address
+	value    string
+}
+
+func movementOf(r *types.TokenRecord) movement {
+	return movement{txHash: r.TxHash, from: r.From, to: r.To, value: r.Value.String()}
+}
+
+// reconcileCELO drops the GoldToken Transfer events that duplicate native
+// CELO movements of traced. CELO is indexed both from call traces and, as
+// the GoldToken ERC20, from its Transfer logs, all under CELO_COINID, so a
+// transfer going through GoldToken can show up twice. Each traced movement
+// cancels at most one event of the same transaction, parties and amount.
+// It returns the remaining events and the number of dropped ones.
+func reconcileCELO(traced, events []*types.TokenRecord) ([]*types.TokenRecord, int) {
+	unmatched := make(map[movement]int)
+	for _, r := range traced {
+		if r.CoinID == types.CELO_COINID && r.LogIndex < 0 && r.FrameType != types.FEE_FRAME {
+			unmatched[movementOf(r)]++
+		}
+	}
+	if len(unmatched) == 0 {
+		return events, 0
+	}
+
+	kept := make([]*types.TokenRecord, 0, len(events))
+	for _, r := range events {
+		if r.CoinID == types.CELO_COINID && r.LogIndex >= 0 {
+			m := movementOf(r)
+			if unmatched[m] > 0 {
+				unmatched[m]--
+				continue
+			}
+		}
+		kept = append(kept, r)
+	}
+	return kept, len(events) - len(kept)
+}
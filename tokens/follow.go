@@ -0,0 +1,220 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/celo-org/celo-blockchain"
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/reorg"
+	"github.com/xuxinlai2002/creda-celo-balance/signal"
+)
+
+// follow keeps indexing new blocks once they have the configured number of
+// confirmations. Blocks more than one log window behind are caught up in
+// batches of the window first. Transfer logs pushed by the logs subscription
+// are used for blocks the subscription fully covers, other ranges and ranges
+// reaching the head are pulled with eth_getLogs. After a reconnect it resumes
+// from the checkpoint, after a reorg from the common ancestor.
+func (s *TokenService) follow(interceptor signal.Interceptor, next uint64) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addresses := make([]common.Address, 0, len(s.tokens))
+	for address := range s.tokens {
+		addresses = append(addresses, address)
+	}
+	query := celo.FilterQuery{
+		Addresses: addresses,
+		Topics:    [][]common.Hash{{crypto.Keccak256Hash(logTransferSig)}},
+	}
+	events := client.Follow(ctx, s.cfg.WS, &query)
+	window := newLogWindow(s.cfg.LogRangeSize, s.cfg.MaxLogRangeSize)
+	log.Infof("following chain head from %v", next)
+
+	// pending holds the pushed logs of blocks not yet confirmed. Only blocks
+	// from coveredFrom on are known to be complete.
+	pending := make(map[uint64][]types.Log)
+	coveredFrom := uint64(math.MaxUint64)
+
+	// restart resumes from the common ancestor of a reorg. Pushed logs of the
+	// orphaned blocks are gone, the canonical ones are pulled again.
+	restart := func(err error) error {
+		var reorgErr *reorg.Error
+		if !errors.As(err, &reorgErr) {
+			return err
+		}
+		next = reorgErr.Ancestor + 1
+		pending = make(map[uint64][]types.Log)
+		coveredFrom = math.MaxUint64
+		return nil
+	}
+
+	for {
+		var ev client.FollowEvent
+		select {
+		case ev = <-events:
+		case <-interceptor.ShutdownChannel():
+			log.Infof("token service shutting down...")
+			return errShutdown
+		}
+
+		if ev.Connected {
+			pending = make(map[uint64][]types.Log)
+			coveredFrom = math.MaxUint64
+			if progress, ok, err := s.reorg.Checkpoint(); err == nil && ok && progress >= next {
+				next = progress + 1
+			}
+		}
+		if ev.Log != nil {
+			pending[ev.Log.BlockNumber] = updatePending(pending[ev.Log.BlockNumber], ev.Log)
+			continue
+		}
+
+		head := ev.Head.Number.Uint64()
+		if coveredFrom == math.MaxUint64 {
+			coveredFrom = head + 1
+		}
+		if head < s.cfg.Confirmations || head-s.cfg.Confirmations < next {
+			continue
+		}
+		confirmed := head - s.cfg.Confirmations
+
+		if next+window.size <= confirmed {
+			var err error
+			if next, err = s.catchUp(ctx, interceptor, window, next, confirmed); err != nil {
+				if err := restart(err); err != nil {
+					return err
+				}
+				continue
+			}
+			if next+window.size <= confirmed {
+				// Fetching headers failed, go on from the next head.
+				continue
+			}
+		}
+		headers, err := s.rangeHeaders(ctx, next, confirmed)
+		if err != nil {
+			log.Warnf("fetch headers %v-%v failed, retrying on next head: %v", next, confirmed, err)
+			continue
+		}
+		if err := s.reorg.Check(ctx, headers[0]); err != nil {
+			if err := restart(err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// The logs of the head itself may still be on their way, they come
+		// from another subscription than the head.
+		if next >= coveredFrom && confirmed < head {
+			logs := make([]types.Log, 0)
+			for n := next; n <= confirmed; n++ {
+				logs = append(logs, pending[n]...)
+			}
+			if err := s.addLogs(logs); err != nil {
+				return err
+			}
+		} else if _, err := s.pullRange(next, confirmed); err != nil {
+			return err
+		}
+		for n := range pending {
+			if n <= confirmed {
+				delete(pending, n)
+			}
+		}
+
+		if err := s.commitRange(headers, confirmed); err != nil {
+			return err
+		}
+		next = confirmed + 1
+	}
+}
+
+// catchUp indexes the blocks from next on in ranges of the log window,
+// committing each, until next is within one window of confirmed. It returns
+// the next height to index, which stays behind when fetching headers fails,
+// and a *reorg.Error when a range does not extend the recorded chain.
+func (s *TokenService) catchUp(ctx context.Context, interceptor signal.Interceptor, window *logWindow, next, confirmed uint64) (uint64, error) {
+	for next+window.size <= confirmed {
+		select {
+		case <-interceptor.ShutdownChannel():
+			log.Infof("token service shutting down...")
+			return next, errShutdown
+		default:
+		}
+
+		to := next + window.size - 1
+		headers, err := s.rangeHeaders(ctx, next, to)
+		if err != nil {
+			log.Warnf("fetch headers %v-%v failed, retrying on next head: %v", next, to, err)
+			return next, nil
+		}
+		if err := s.reorg.Check(ctx, headers[0]); err != nil {
+			return next, err
+		}
+		fitted, err := s.pullRange(next, to)
+		if err != nil {
+			return next, err
+		}
+		if err := s.commitRange(headers, to); err != nil {
+			return next, err
+		}
+		window.update(to-next+1, fitted)
+		next = to + 1
+	}
+	return next, nil
+}
+
+// commitRange hands the records taken so far to the persister together with
+// headers and waits for the commit, the next reorg check reads the recorded
+// blocks.
+func (s *TokenService) commitRange(headers []*types.Header, height uint64) error {
+	if err := s.persist.enqueue(&batch{records: s.takeRecords(), headers: headers, height: height}); err != nil {
+		return err
+	}
+	if err := s.persist.wait(); err != nil {
+		return err
+	}
+	log.Infof("indexed token logs up to %v", height)
+	return nil
+}
+
+// updatePending adds a pushed log to the logs of its block, or drops it again
+// when the node reports it as removed by a reorg.
+func updatePending(logs []types.Log, l *types.Log) []types.Log {
+	if !l.Removed {
+		return append(logs, *l)
+	}
+	for i := range logs {
+		if logs[i].TxHash == l.TxHash && logs[i].Index == l.Index {
+			return append(logs[:i], logs[i+1:]...)
+		}
+	}
+	return logs
+}
+
+// rangeHeaders fetches the headers from one block to another and checks that
+// they form a chain.
+func (s *TokenService) rangeHeaders(ctx context.Context, from, to uint64) ([]*types.Header, error) {
+	numbers := make([]*big.Int, 0, to-from+1)
+	for n := from; n <= to; n++ {
+		numbers = append(numbers, new(big.Int).SetUint64(n))
+	}
+	headers, errs := s.cli.HeadersByNumber(ctx, numbers)
+	for i := range headers {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if i > 0 && headers[i].ParentHash != headers[i-1].Hash() {
+			return nil, fmt.Errorf("block %v does not extend block %v", headers[i].Number, headers[i-1].Number)
+		}
+	}
+	return headers, nil
+}
@@ -0,0 +1,89 @@
+package tokens
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/client/mocknode"
+	"github.com/xuxinlai2002/creda-celo-balance/config"
+	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/db/dbtest"
+	"github.com/xuxinlai2002/creda-celo-balance/opening"
+	"github.com/xuxinlai2002/creda-celo-balance/reorg"
+	"github.com/xuxinlai2002/creda-celo-balance/signal"
+)
+
+// TestCatchUp tests that a gap of several log windows is indexed one window
+// at a time, each committed before the next is pulled, leaving the last
+// window to the pushed logs.
+func TestCatchUp(t *testing.T) {
+	node := mocknode.New(1000)
+	defer node.Close()
+	node.ChainID = 1337
+	for i := 1; i <= 10; i++ {
+		node.AddBlock(uint64(1000+5*i), &mocknode.Tx{
+			From: alice,
+			To:   &cUSD,
+			Logs: []*mocknode.Log{mocknode.TransferLog(cUSD, alice, bob, big.NewInt(int64(i)))},
+		})
+	}
+
+	s := newTestService(t, node)
+	s.cfg = &config.Config{LogRangeSize: 3, MaxLogRangeSize: 3}
+	database, err := db.NewDB("creda", "", "", dbtest.Use(t), 5432)
+	require.NoError(t, err)
+	defer database.Close()
+	s.reorg, err = reorg.New(s.cli, database, indexerName, "event")
+	require.NoError(t, err)
+	s.openings, err = opening.New(context.Background(), s.cfg, s.cli, database, s.tokens)
+	require.NoError(t, err)
+
+	// committed holds the checkpoint and the blocks of the records of each
+	// commit.
+	type commit struct {
+		height uint64
+		blocks []uint64
+	}
+	var committed []commit
+	s.persist = newPersister(persistQueueSize, func(b *batch) error {
+		c := commit{height: b.height}
+		for _, records := range b.records {
+			for _, r := range records {
+				c.blocks = append(c.blocks, r.BlockNumber)
+			}
+		}
+		committed = append(committed, c)
+		return s.commitBatch(b)
+	})
+	defer s.persist.close()
+
+	window := newLogWindow(s.cfg.LogRangeSize, s.cfg.MaxLogRangeSize)
+	next, err := s.catchUp(context.Background(), signal.Interceptor{}, window, 1, 10)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), next)
+	require.Equal(t, []commit{
+		{height: 3, blocks: []uint64{1, 2, 3}},
+		{height: 6, blocks: []uint64{4, 5, 6}},
+		{height: 9, blocks: []uint64{7, 8, 9}},
+	}, committed)
+	require.Equal(t, 3, node.Calls("eth_getLogs"))
+
+	height, ok, err := s.reorg.Checkpoint()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(9), height)
+	for n := uint64(1); n <= 9; n++ {
+		hash, ok, err := database.GetBlockHash(indexerName, n)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, node.Header(n).Hash(), hash)
+	}
+
+	// Within one window of the head there is nothing to catch up.
+	next, err = s.catchUp(context.Background(), signal.Interceptor{}, window, 10, 12)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), next)
+	require.Len(t, committed, 3)
+}
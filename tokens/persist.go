@@ -0,0 +1,104 @@
+package tokens
+
+import (
+	"context"
+	"sync"
+
+	"github.com/celo-org/celo-blockchain/core/types"
+	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+// persistQueueSize is the number of pulled ranges that may wait for the
+// database before pulling blocks.
+const persistQueueSize = 4
+
+// batch holds the records of a pulled block range.
+type batch struct {
+	records map[string][]*ctypes.TokenRecord // day buckets
+	headers []*types.Header                  // blocks to record for reorg detection
+	height  uint64                           // checkpoint once committed
+}
+
+// persister commits batches in order on its own goroutine. The queue is
+// bounded, so enqueueing blocks while the database lags behind. Once a batch
+// fails no later batch is committed, so the checkpoint never skips a
+// range that was not stored.
+type persister struct {
+	commit func(*batch) error
+
+	queue   chan *batch
+	pending sync.WaitGroup
+	done    chan struct{}
+
+	lock sync.Mutex
+	err  error
+}
+
+func newPersister(size int, commit func(*batch) error) *persister {
+	p := &persister{
+		commit: commit,
+		queue:  make(chan *batch, size),
+		done:   make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+func (p *persister) loop() {
+	defer close(p.done)
+	for b := range p.queue {
+		if p.failed() == nil {
+			if err := p.commit(b); err != nil {
+				log.Errorf("persist token records up to %v failed: %v", b.height, err)
+				p.lock.Lock()
+				p.err = err
+				p.lock.Unlock()
+			}
+		}
+		p.pending.Done()
+	}
+}
+
+func (p *persister) failed() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.err
+}
+
+// enqueue queues b, waiting while the queue is full. It returns the error of
+// an earlier batch that failed.
+func (p *persister) enqueue(b *batch) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+	p.pending.Add(1)
+	p.queue <- b
+	return nil
+}
+
+// wait blocks until every queued batch is committed.
+func (p *persister) wait() error {
+	p.pending.Wait()
+	return p.failed()
+}
+
+// close commits the queued batches and stops the persister.
+func (p *persister) close() error {
+	close(p.queue)
+	<-p.done
+	return p.failed()
+}
+
+// commitBatch seeds the accounts seen for the first time, then stores the
+// day buckets of b, records its blocks and advances the token checkpoint in
+// one database transaction.
+func (s *TokenService) commitBatch(b *batch) error {
+	tables := make(map[string][]*ctypes.TokenRecord, len(b.records))
+	for date, records := range b.records {
+		tables["event"+date] = records
+	}
+	if err := s.openings.Seed(context.Background(), tables); err != nil {
+		return err
+	}
+	return s.reorg.Commit(b.height, tables, nil, b.headers...)
+}
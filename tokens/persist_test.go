@@ -0,0 +1,70 @@
+package tokens
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestPersisterOrder tests that batches are committed in order and that no
+// batch is committed after a failed one.
+func TestPersisterOrder(t *testing.T) {
+	var (
+		lock      sync.Mutex
+		committed []uint64
+	)
+	p := newPersister(2, func(b *batch) error {
+		if b.height == 3 {
+			return errors.New("db down")
+		}
+		lock.Lock()
+		committed = append(committed, b.height)
+		lock.Unlock()
+		return nil
+	})
+
+	for height := uint64(1); height <= 2; height++ {
+		require.NoError(t, p.enqueue(&batch{height: height}))
+	}
+	require.NoError(t, p.wait())
+	require.Equal(t, []uint64{1, 2}, committed)
+
+	require.NoError(t, p.enqueue(&batch{height: 3}))
+	require.NoError(t, p.enqueue(&batch{height: 4}))
+	require.EqualError(t, p.wait(), "db down")
+	require.EqualError(t, p.enqueue(&batch{height: 5}), "db down")
+	require.EqualError(t, p.close(), "db down")
+	require.Equal(t, []uint64{1, 2}, committed)
+}
+
+// TestPersisterBackpressure tests that enqueueing blocks while the queue is
+// full.
+func TestPersisterBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	p := newPersister(1, func(b *batch) error {
+		<-release
+		return nil
+	})
+
+	// One batch is being committed, one waits in the queue.
+	require.NoError(t, p.enqueue(&batch{height: 1}))
+	require.NoError(t, p.enqueue(&batch{height: 2}))
+
+	enqueued := make(chan struct{})
+	go func() {
+		p.enqueue(&batch{height: 3})
+		close(enqueued)
+	}()
+	select {
+	case <-enqueued:
+		t.Fatal("enqueue did not block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-enqueued
+	require.NoError(t, p.close())
+}
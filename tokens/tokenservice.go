@@ -9,25 +9,49 @@ import (
 	"time"
 
 	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/core/types"
 	_ "github.com/lib/pq"
 	"github.com/xuxinlai2002/creda-celo-balance/client"
 	"github.com/xuxinlai2002/creda-celo-balance/config"
 	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/metadata"
+	"github.com/xuxinlai2002/creda-celo-balance/opening"
+	"github.com/xuxinlai2002/creda-celo-balance/reorg"
 	"github.com/xuxinlai2002/creda-celo-balance/signal"
 	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
 	"github.com/xuxinlai2002/creda-celo-balance/utils"
 )
 
+// blockTimesCacheSize is the number of block timestamps kept in memory.
+const blockTimesCacheSize = 100000
+
+// indexerName names the checkpoint and recorded blocks of the token indexer.
+const indexerName = "tokens"
+
+var logTransferSig = []byte("Transfer(address,address,uint256)")
+
+var errShutdown = errors.New("shutdown requested")
+
 type TokenService struct {
 	cli      *client.Client
+	times    *client.BlockTimes
 	cfg      *config.Config
+	tokens   map[common.Address]*ctypes.Token
 	records  map[string][]*ctypes.TokenRecord
 	database *db.PostgresDB
+	reorg    *reorg.Detector
+	openings *opening.Seeder
+	persist  *persister
 	wg       *sync.WaitGroup
 }
 
 func NewService(cfg *config.Config, wg *sync.WaitGroup) (*TokenService, error) {
-	cli, err := client.Dial(cfg.HTTP)
+	cli, err := client.DialConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	times, err := client.NewBlockTimes(cli, blockTimesCacheSize)
 	if err != nil {
 		return nil, err
 	}
@@ -37,13 +61,43 @@ func NewService(cfg *config.Config, wg *sync.WaitGroup) (*TokenService, error) {
 		return nil, errors.New(fmt.Sprintf("new db err: %v", err))
 	}
 
-	return &TokenService{
+	detector, err := reorg.New(cli, database, indexerName, "event")
+	if err != nil {
+		return nil, err
+	}
+	if err := utils.ImportTokenCurrentHeight(database, indexerName); err != nil {
+		return nil, errors.New(fmt.Sprintf("import token progress err: %v", err))
+	}
+
+	tokens, err := metadata.Load(cfg.TokenRegistry)
+	if err != nil {
+		return nil, err
+	}
+	if err := metadata.Verify(context.Background(), cli, tokens); err != nil {
+		return nil, err
+	}
+	if err := metadata.Store(database, tokens); err != nil {
+		return nil, errors.New(fmt.Sprintf("store token metadata err: %v", err))
+	}
+	log.Infof("tracking %d tokens", len(tokens))
+	seeder, err := opening.New(context.Background(), cfg, cli, database, tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &TokenService{
 		cli:      cli,
+		times:    times,
 		cfg:      cfg,
+		tokens:   tokens,
 		records:  make(map[string][]*ctypes.TokenRecord),
 		database: database,
+		reorg:    detector,
+		openings: seeder,
 		wg:       wg,
-	}, nil
+	}
+	s.persist = newPersister(persistQueueSize, s.commitBatch)
+	return s, nil
 }
 
 func (s *TokenService) Start(interceptor signal.Interceptor) error {
@@ -57,116 +111,163 @@ func (s *TokenService) Start(interceptor signal.Interceptor) error {
 	return nil
 }
 
-func (s *TokenService) persistToDB(date string, records []*ctypes.TokenRecord) error {
-	tableName := fmt.Sprintf("event%s", date)
-	if err := s.database.CreateRecordTable(tableName); err != nil {
-		return errors.New(fmt.Sprintf("token service persist db err: %v", err))
+// logTimes returns the timestamps of the blocks referenced by logs.
+func (s *TokenService) logTimes(logs []types.Log) (map[uint64]uint64, error) {
+	numbers := make([]uint64, len(logs))
+	for i, vlog := range logs {
+		numbers[i] = vlog.BlockNumber
 	}
+	return s.times.Resolve(context.Background(), numbers)
+}
 
-	if err := s.database.InsertRecords(tableName, records); err != nil {
-		return errors.New(fmt.Sprintf("token service persist db err: %v", err))
+// addLogs turns Transfer logs of tracked tokens into records, bucketed by
+// the day of their block until the range is handed to the persister.
+func (s *TokenService) addLogs(logs []types.Log) error {
+	if len(logs) == 0 {
+		return nil
 	}
+	times, err := s.logTimes(logs)
+	if err != nil {
+		return fmt.Errorf("resolve block times failed, error: %v", err)
+	}
+	for _, vlog := range logs {
+		tokenInfo, ok := s.tokens[vlog.Address]
+		if !ok || len(vlog.Topics) < 3 || vlog.BlockNumber < tokenInfo.StartBlock {
+			continue
+		}
+		// Logs of system calls carry the block hash as tx hash. They are
+		// epoch rewards, recorded by the tx indexer.
+		if vlog.TxHash == vlog.BlockHash {
+			continue
+		}
+		tr := &ctypes.TokenRecord{
+			CoinID:      tokenInfo.CoinID,
+			BlockNumber: vlog.BlockNumber,
+			Timestamp:   times[vlog.BlockNumber],
+			TxHash:      vlog.TxHash,
+			TxIndex:     vlog.TxIndex,
+			From:        common.HexToAddress(vlog.Topics[1].Hex()),
+			To:          common.HexToAddress(vlog.Topics[2].Hex()),
+			Value:       big.NewInt(0).SetBytes(vlog.Data),
+			LogIndex:    int(vlog.Index),
+		}
+		if tr.Value.Cmp(big.NewInt(0)) <= 0 {
+			continue
+		}
 
-	log.Infof("saved into table %s", tableName)
+		t := time.Unix(int64(tr.Timestamp), 0)
+		date := fmt.Sprintf("%04d%02d%02d", t.Year(), int(t.Month()), t.Day())
 
+		s.records[date] = append(s.records[date], tr)
+	}
 	return nil
 }
 
-func (s *TokenService) processERC20Tokens(interceptor signal.Interceptor) {
-	tokens := ERC20Tokens
-	distance := uint64(10000)
-	toBlock := uint64(0)
-	logTransferSig := []byte("Transfer(address,address,uint256)")
+// takeRecords returns the buffered day buckets and starts new ones.
+func (s *TokenService) takeRecords() map[string][]*ctypes.TokenRecord {
+	records := s.records
+	s.records = make(map[string][]*ctypes.TokenRecord)
+	return records
+}
+
+// pullRange fetches the Transfer logs of all tokens between from and to with
+// a single query, dispatched to the tokens by contract address. Tokens whose
+// start block is after to are left out. It returns the largest block span a
+// single query succeeded with.
+func (s *TokenService) pullRange(from, to uint64) (uint64, error) {
+	addresses := make([]common.Address, 0, len(s.tokens))
+	for address, token := range s.tokens {
+		if token.StartBlock <= to {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		return to - from + 1, nil
+	}
 
+	query := s.cli.BuildMultiQuery(addresses, logTransferSig, big.NewInt(0).SetUint64(from), big.NewInt(0).SetUint64(to))
+	logs, fitted, err := s.cli.FilterLogsSplit(context.Background(), query)
+	if err != nil {
+		return 0, fmt.Errorf("filter logs failed, error: %v", err)
+	}
+	if len(logs) > 0 {
+		log.Infof("blocks %v-%v: %v transfer logs", from, to, len(logs))
+	}
+	if err := s.addLogs(logs); err != nil {
+		return 0, err
+	}
+	return fitted, nil
+}
+
+func (s *TokenService) processERC20Tokens(interceptor signal.Interceptor) {
 	startHeight := s.cfg.StartBlock
 
-	progress, err := utils.GetTokenCurrentHeight()
-	log.Infof("token start height: %v", progress)
-	if err == nil && progress > startHeight {
+	progress, ok, err := s.reorg.Checkpoint()
+	if err != nil {
+		log.Errorf("token service stopped: read checkpoint err: %v", err)
+		s.closeService()
+		return
+	}
+	log.Infof("token checkpoint: %v", progress)
+	if ok && progress >= startHeight {
 		startHeight = progress + 1
 	}
 
-	for i := startHeight; i < s.cfg.EndBlock; i = toBlock + 1 {
-		if i+distance < s.cfg.EndBlock {
-			toBlock = i + distance
+	next, err := s.backfill(interceptor, startHeight)
+	if err == nil && s.cfg.Follow {
+		err = s.follow(interceptor, next)
+	}
+	if err != nil && err != errShutdown {
+		// The client already retried, stop here so that the range is
+		// pulled again on the next run.
+		log.Errorf("token service stopped: %v", err)
+	}
+
+	// Records of a range that was not pulled completely are dropped, the
+	// range is pulled again on the next run.
+	s.takeRecords()
+	s.closeService()
+}
+
+// closeService commits the queued batches and releases the connections.
+func (s *TokenService) closeService() {
+	if err := s.persist.close(); err != nil {
+		log.Errorf("token records not persisted: %v", err)
+	}
+	s.database.Close()
+	s.cli.Close()
+	log.Infof("token service finished")
+}
+
+// backfill pulls the token logs from startHeight up to the configured end
+// block. It returns the next height to pull.
+func (s *TokenService) backfill(interceptor signal.Interceptor, startHeight uint64) (uint64, error) {
+	window := newLogWindow(s.cfg.LogRangeSize, s.cfg.MaxLogRangeSize)
+	toBlock := uint64(0)
+
+	i := startHeight
+	for ; i < s.cfg.EndBlock; i = toBlock + 1 {
+		select {
+		case <-interceptor.ShutdownChannel():
+			log.Infof("token service shutting down...")
+			return 0, errShutdown
+		default:
+		}
+
+		if i+window.size <= s.cfg.EndBlock {
+			toBlock = i + window.size - 1
 		} else {
 			toBlock = s.cfg.EndBlock
 		}
 		log.Infof("pull block from %v to %v", i, toBlock)
-		for address, tokenInfo := range tokens {
-			select {
-			default:
-				query := s.cli.BuildQuery(address, logTransferSig, big.NewInt(0).SetUint64(i), big.NewInt(0).SetUint64(toBlock))
-				logs, err := s.cli.FilterLogs(context.Background(), query)
-				if err != nil {
-					log.Errorf("filter logs failed, error: %v", err)
-				} else if len(logs) > 0 {
-					log.Infof("addr: %v, len(logs): %v", address, len(logs))
-					for _, vlog := range logs {
-						bn := big.NewInt(0)
-						bn.SetUint64(vlog.BlockNumber)
-						b, err := s.cli.BlockByNumber(context.Background(), bn)
-						if err != nil {
-							log.Errorf("rpc.BlockByNumber err: %v", err)
-						} else {
-							tr := &ctypes.TokenRecord{
-								CoinID:      tokenInfo.CoinID,
-								BlockNumber: vlog.BlockNumber,
-								Timestamp:   b.Header().Time,
-								TxHash:      vlog.TxHash,
-								From:        common.HexToAddress(vlog.Topics[1].Hex()),
-								To:          common.HexToAddress(vlog.Topics[2].Hex()),
-								Value:       big.NewInt(0).SetBytes(vlog.Data),
-							}
-							if tr.Value.Cmp(big.NewInt(0)) <= 0 {
-								continue
-							}
-
-							t := time.Unix(int64(tr.Timestamp), 0)
-							date := fmt.Sprintf("%04d%02d%02d", t.Year(), int(t.Month()), t.Day())
-
-							if _, ok := s.records[date]; ok {
-								s.records[date] = append(s.records[date], tr)
-							} else {
-								if len(s.records) > 0 {
-									for k, v := range s.records {
-										records := v
-										delete(s.records, k)
-
-										s.wg.Add(1)
-										go func() {
-											s.wg.Done()
-											if err := s.persistToDB(k, records); err != nil {
-												log.Errorf("persist token event to db err: %v", err)
-											}
-										}()
-									}
-								}
-								s.records[date] = []*ctypes.TokenRecord{tr}
-							}
-						}
-					}
-					utils.WriteTokenCurrentHeight(toBlock)
-				}
-
-			case <-interceptor.ShutdownChannel():
-				log.Infof("token service shutting down...")
-				goto shutdown
-			}
+		fitted, err := s.pullRange(i, toBlock)
+		if err != nil {
+			return 0, err
 		}
-	}
-
-shutdown:
-	if len(s.records) > 0 {
-		for k, v := range s.records {
-			if err := s.persistToDB(k, v); err != nil {
-				log.Errorf("persist token event to db err: %v", err)
-			}
-
-			delete(s.records, k)
+		if err := s.persist.enqueue(&batch{records: s.takeRecords(), height: toBlock}); err != nil {
+			return 0, err
 		}
+		window.update(toBlock-i+1, fitted)
 	}
-
-	s.database.Close()
-	log.Infof("token service finished")
+	return i, nil
 }
@@ -0,0 +1,121 @@
+package tokens
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/client/mocknode"
+	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+var (
+	alice   = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	bob     = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+	cUSD    = common.HexToAddress("0x765DE816845861e75A25fCA122bb6898B8B1282a")
+	cEUR    = common.HexToAddress("0xD8763CBa276a3738E6DE85b4b3bF5FDed6D6cA73")
+	unknown = common.HexToAddress("0x000000000000000000000000000000000000dead")
+)
+
+// testTokens is the registry of the tests.
+var testTokens = map[common.Address]*ctypes.Token{
+	cUSD: {Address: cUSD, Name: "cUSD", CoinID: 7236, Decimals: 18},
+	cEUR: {Address: cEUR, Name: "cEUR", CoinID: 9467, Decimals: 18},
+}
+
+func newTestService(t *testing.T, node *mocknode.Node) *TokenService {
+	cli, err := client.Dial(node.URL)
+	require.NoError(t, err)
+	t.Cleanup(cli.Close)
+	times, err := client.NewBlockTimes(cli, 16)
+	require.NoError(t, err)
+	return &TokenService{
+		cli:     cli,
+		times:   times,
+		tokens:  testTokens,
+		records: make(map[string][]*ctypes.TokenRecord),
+		wg:      &sync.WaitGroup{},
+	}
+}
+
+// TestPullRange tests that Transfer logs of tracked tokens become records
+// with their block time, that all tokens are fetched with one query and that
+// each block header is fetched once. Logs of system calls are skipped.
+func TestPullRange(t *testing.T) {
+	// 2020-05-01 13:00:00 UTC.
+	const day = 1588338000
+	node := mocknode.New(day)
+	defer node.Close()
+	node.AddBlock(day+5, &mocknode.Tx{
+		From: alice,
+		To:   &cUSD,
+		Logs: []*mocknode.Log{
+			mocknode.TransferLog(cUSD, alice, bob, big.NewInt(100)),
+			mocknode.TransferLog(cEUR, alice, bob, big.NewInt(200)),
+			mocknode.TransferLog(unknown, alice, bob, big.NewInt(300)),
+			mocknode.TransferLog(cUSD, alice, bob, big.NewInt(0)),
+		},
+	})
+	node.AddBlock(day+10, &mocknode.Tx{
+		From: bob,
+		To:   &cUSD,
+		Logs: []*mocknode.Log{mocknode.TransferLog(cUSD, bob, alice, big.NewInt(40))},
+	})
+	// Epoch rewards are left to the tx indexer.
+	node.AddSystemLogs(2, mocknode.TransferLog(cUSD, common.ZeroAddress, alice, big.NewInt(500)))
+	s := newTestService(t, node)
+
+	_, err := s.pullRange(1, 2)
+	require.NoError(t, err)
+	require.Len(t, s.records, 1)
+
+	var records []*ctypes.TokenRecord
+	for _, rs := range s.records {
+		records = rs
+	}
+	require.Len(t, records, 3)
+
+	values := make(map[uint64][]int64)
+	for _, r := range records {
+		values[r.CoinID] = append(values[r.CoinID], r.Value.Int64())
+		require.Equal(t, day+5+5*(r.BlockNumber-1), r.Timestamp)
+	}
+	require.ElementsMatch(t, []int64{100, 40}, values[7236])
+	require.Equal(t, []int64{200}, values[9467])
+	require.Equal(t, 1, node.Calls("eth_getLogs"))
+	require.Equal(t, 2, node.Calls("eth_getBlockByNumber"))
+}
+
+// TestPullRangeStartBlock tests that logs before the start block of a token
+// are neither queried nor recorded.
+func TestPullRangeStartBlock(t *testing.T) {
+	node := mocknode.New(1000)
+	defer node.Close()
+	for i := 1; i <= 3; i++ {
+		node.AddBlock(uint64(1000+i), &mocknode.Tx{
+			From: alice,
+			To:   &cUSD,
+			Logs: []*mocknode.Log{mocknode.TransferLog(cUSD, alice, bob, big.NewInt(int64(i)))},
+		})
+	}
+	s := newTestService(t, node)
+	s.tokens = map[common.Address]*ctypes.Token{cUSD: {Address: cUSD, Name: "cUSD", CoinID: 7236, Decimals: 18, StartBlock: 2}}
+
+	_, err := s.pullRange(1, 1)
+	require.NoError(t, err)
+	require.Equal(t, 0, node.Calls("eth_getLogs"))
+
+	_, err = s.pullRange(1, 3)
+	require.NoError(t, err)
+	var blocks []uint64
+	for _, rs := range s.records {
+		for _, r := range rs {
+			blocks = append(blocks, r.BlockNumber)
+		}
+	}
+	require.ElementsMatch(t, []uint64{2, 3}, blocks)
+	require.Equal(t, 1, node.Calls("eth_getLogs"))
+}
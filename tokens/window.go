@@ -0,0 +1,52 @@
+package tokens
+
+// growAfter is the number of ranges in a row that have to be fetched without
+// splitting before the log window grows again.
+const growAfter = 5
+
+// logWindow sizes the block ranges of eth_getLogs queries. It shrinks to what
+// the node accepted when a range had to be split and doubles again after a run
+// of successes, so sparse periods move quickly and dense ones don't fail.
+type logWindow struct {
+	size      uint64
+	max       uint64
+	successes int
+}
+
+func newLogWindow(size, max uint64) *logWindow {
+	if size == 0 {
+		size = 1
+	}
+	if max < size {
+		max = size
+	}
+	return &logWindow{
+		size: size,
+		max:  max,
+	}
+}
+
+// update records the outcome of a range of span blocks of which at most
+// fitted blocks could be fetched in a single request.
+func (w *logWindow) update(span, fitted uint64) {
+	if fitted < span {
+		w.size = fitted
+		if w.size == 0 {
+			w.size = 1
+		}
+		w.successes = 0
+		log.Infof("log window shrunk to %v blocks", w.size)
+		return
+	}
+
+	w.successes++
+	if w.successes < growAfter || w.size >= w.max {
+		return
+	}
+	w.size *= 2
+	if w.size > w.max {
+		w.size = w.max
+	}
+	w.successes = 0
+	log.Debugf("log window grown to %v blocks", w.size)
+}
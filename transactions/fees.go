@@ -0,0 +1,168 @@
+package transactions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/contracts/config"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/celo-blockchain/params"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+// The trace addresses of fee records. They lie outside of the call tree, so
+// fee records never collide with transfers of the same transaction.
+const (
+	baseFeeAddress    = "fee.base"
+	tipAddress        = "fee.tip"
+	gatewayFeeAddress = "fee.gateway"
+)
+
+// chainConfig returns the fork schedule of the chain with the given id. Other
+// chains are assumed to have every fork active from genesis.
+func chainConfig(chainID *big.Int) *params.ChainConfig {
+	switch chainID.Uint64() {
+	case params.MainnetNetworkId:
+		return params.MainnetChainConfig
+	case params.BaklavaNetworkId:
+		return params.BaklavaChainConfig
+	case params.AlfajoresNetworkId:
+		return params.AlfajoresChainConfig
+	}
+	return params.TestChainConfig
+}
+
+// blockFees resolves the fee parameters of one block from the state of its
+// parent, the state the transactions of the block are charged against.
+type blockFees struct {
+	p          *BlockPull
+	parent     *big.Int
+	feeHandler common.Address
+	goldToken  *common.Address
+	gpm        map[common.Address]*big.Int
+}
+
+// gasPriceMinimum returns the gas price minimum in currency, nil meaning CELO.
+func (f *blockFees) gasPriceMinimum(ctx context.Context, currency *common.Address) (*big.Int, error) {
+	if currency == nil {
+		if f.goldToken == nil {
+			address, err := f.p.client.RegisteredAddress(ctx, config.GoldTokenRegistryId, f.parent)
+			if err != nil {
+				return nil, err
+			}
+			f.goldToken = &address
+		}
+		currency = f.goldToken
+	}
+	if gpm, ok := f.gpm[*currency]; ok {
+		return gpm, nil
+	}
+	gpm, err := f.p.client.GasPriceMinimum(ctx, *currency, f.parent)
+	if err != nil {
+		return nil, err
+	}
+	f.gpm[*currency] = gpm
+	return gpm, nil
+}
+
+// logsGasFees reports whether the fee currency of the tx of receipt r logs
+// the gas fees itself. StableTokenV2 burns the fee from the sender before the
+// tx runs and mints the refund and the fees after it, so its Transfer logs,
+// recorded by the token indexer, open and close the receipt.
+func logsGasFees(r *client.TxReceipt, currency common.Address) bool {
+	if len(r.Logs) == 0 {
+		return false
+	}
+	l := r.Logs[0]
+	return l.Address == currency && len(l.Topics) == 3 && l.Topics[0] == transferTopic &&
+		common.BytesToAddress(l.Topics[1].Bytes()) == r.From && common.BytesToAddress(l.Topics[2].Bytes()) == common.ZeroAddress
+}
+
+// processFees records the fee of every transaction of b as transfers from its
+// sender in its fee currency: the base fee to the fee handler, the tip to the
+// coinbase of b and the gateway fee to the gateway fee recipient. Fees the
+// fee currency logs as Transfers itself are left to the token indexer.
+func (p *BlockPull) processFees(ctx context.Context, b *types.Block, receipts []*client.TxReceipt, filePath string) error {
+	txs := b.Transactions()
+	if len(txs) == 0 {
+		return nil
+	}
+
+	// Since Gingerbread the base fee goes to the FeeHandler, before to
+	// Governance. Without either it is refunded to the sender.
+	f := &blockFees{
+		p:      p,
+		parent: new(big.Int).Sub(b.Number(), big.NewInt(1)),
+		gpm:    make(map[common.Address]*big.Int),
+	}
+	feeHandlerID := config.GovernanceRegistryId
+	if p.chainConfig.IsGingerbread(b.Number()) {
+		feeHandlerID = config.FeeHandlerId
+	}
+	var err error
+	if f.feeHandler, err = p.client.RegisteredAddress(ctx, feeHandlerID, f.parent); err != nil {
+		return err
+	}
+
+	for i, tx := range txs {
+		coinID := uint64(ctypes.CELO_COINID)
+		if currency := tx.FeeCurrency(); currency != nil {
+			token, ok := p.tokens[*currency]
+			if !ok {
+				return errors.New(fmt.Sprintf("tx %v pays its fee in %v, which is missing from the token registry", tx.Hash(), currency))
+			}
+			if logsGasFees(receipts[i], *currency) {
+				continue
+			}
+			coinID = token.CoinID
+		}
+		gpm, err := f.gasPriceMinimum(ctx, tx.FeeCurrency())
+		if err != nil {
+			return errors.New(fmt.Sprintf("gas price minimum of block %v err: %v", b.NumberU64(), err))
+		}
+
+		r := receipts[i]
+		price := (*big.Int)(r.EffectiveGasPrice)
+		if price == nil {
+			price = new(big.Int).Add(gpm, tx.EffectiveGasTipValue(gpm))
+		}
+		gasUsed := new(big.Int).SetUint64(uint64(r.GasUsed))
+		total := new(big.Int).Mul(gasUsed, price)
+		base := new(big.Int).Mul(gasUsed, gpm)
+		if base.Cmp(total) > 0 {
+			base.Set(total)
+		}
+		tip := new(big.Int).Sub(total, base)
+
+		record := func(to common.Address, value *big.Int, traceAddress string) {
+			if value.Sign() <= 0 {
+				return
+			}
+			p.addPullTxRecord(filePath, &ctypes.TokenRecord{
+				CoinID:       coinID,
+				BlockNumber:  b.NumberU64(),
+				Timestamp:    b.Time(),
+				TxHash:       tx.Hash(),
+				TxIndex:      uint(i),
+				From:         r.From,
+				To:           to,
+				Value:        value,
+				LogIndex:     -1,
+				TraceAddress: traceAddress,
+				FrameType:    ctypes.FEE_FRAME,
+			})
+		}
+		if f.feeHandler != (common.Address{}) {
+			record(f.feeHandler, base, baseFeeAddress)
+		}
+		record(b.Coinbase(), tip, tipAddress)
+		if recipient := tx.GatewayFeeRecipient(); recipient != nil {
+			record(*recipient, tx.GatewayFee(), gatewayFeeAddress)
+		}
+	}
+	return nil
+}
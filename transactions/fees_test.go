@@ -0,0 +1,95 @@
+package transactions
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/contracts/config"
+	"github.com/celo-org/celo-blockchain/params"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/client/mocknode"
+	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+// TestProcessFees tests that fees are debited from senders in their fee
+// currency and credited to the fee handler, coinbase and gateway, unless the
+// fee currency logs them.
+func TestProcessFees(t *testing.T) {
+	var (
+		cUSD       = common.HexToAddress("0x765DE816845861e75A25fCA122bb6898B8B1282a")
+		goldToken  = common.HexToAddress("0x471EcE3750Da237f93B8E339c536989b8978a438")
+		gpmAddress = common.HexToAddress("0x00000000000000000000000000000000000006a5")
+		governance = common.HexToAddress("0x000000000000000000000000000000000000900e")
+		miner      = common.HexToAddress("0x000000000000000000000000000000000000c01b")
+		gateway    = common.HexToAddress("0x0000000000000000000000000000000000000a7e")
+	)
+
+	node := mocknode.New(1000)
+	defer node.Close()
+	node.Coinbase = miner
+	node.SetRegistered(config.GoldTokenRegistryId, goldToken)
+	node.SetRegistered(config.GovernanceRegistryId, governance)
+	node.SetGasPriceMinimum(gpmAddress, goldToken, big.NewInt(2))
+	node.SetGasPriceMinimum(gpmAddress, cUSD, big.NewInt(3))
+	celoTx := &mocknode.Tx{
+		From: alice, To: &bob, Value: big.NewInt(1),
+		GasPrice: big.NewInt(5), GasUsed: 21000, EffectiveGasPrice: big.NewInt(5),
+		GatewayFee: big.NewInt(100), GatewayFeeRecipient: &gateway,
+	}
+	// Without effectiveGasPrice in the receipt the price is the gas price
+	// minimum plus the tip, capped by the fee cap.
+	cUSDTx := &mocknode.Tx{
+		Type: 0x7c, From: carol, To: &bob, FeeCurrency: &cUSD,
+		GasPrice: big.NewInt(10), GasTipCap: big.NewInt(1), GasUsed: 50000,
+	}
+	// StableTokenV2 burns the fee up front and mints the refund and the fees
+	// after the tx, logging each.
+	loggedTx := &mocknode.Tx{
+		Type: 0x7c, From: bob, To: &cUSD, FeeCurrency: &cUSD,
+		GasPrice: big.NewInt(4), GasUsed: 40000, EffectiveGasPrice: big.NewInt(4),
+		Logs: []*mocknode.Log{
+			mocknode.TransferLog(cUSD, bob, common.ZeroAddress, big.NewInt(200000)),
+			mocknode.TransferLog(cUSD, bob, alice, big.NewInt(7)),
+			mocknode.TransferLog(cUSD, common.ZeroAddress, bob, big.NewInt(40000)),
+			mocknode.TransferLog(cUSD, common.ZeroAddress, miner, big.NewInt(40000)),
+			mocknode.TransferLog(cUSD, common.ZeroAddress, governance, big.NewInt(120000)),
+		},
+	}
+	node.AddBlock(1005, celoTx, cUSDTx, loggedTx)
+
+	p := newTestPull(t, node)
+	p.chainConfig = params.MainnetChainConfig
+	p.tokens = map[common.Address]*ctypes.Token{cUSD: {Address: cUSD, CoinID: 7236}}
+	b, err := p.client.BlockByNumber(context.Background(), big.NewInt(1))
+	require.NoError(t, err)
+	receipts, err := p.receipts(context.Background(), b)
+	require.NoError(t, err)
+	require.NoError(t, p.processFees(context.Background(), b, receipts, "tx_test"))
+
+	type fee struct {
+		coinID   uint64
+		from, to common.Address
+		value    int64
+		address  string
+	}
+	var got []fee
+	for _, r := range p.pullTxList["tx_test"] {
+		require.Equal(t, ctypes.FEE_FRAME, r.FrameType)
+		require.Equal(t, -1, r.LogIndex)
+		got = append(got, fee{r.CoinID, r.From, r.To, r.Value.Int64(), r.TraceAddress})
+	}
+	require.Equal(t, []fee{
+		{ctypes.CELO_COINID, alice, governance, 21000 * 2, "fee.base"},
+		{ctypes.CELO_COINID, alice, miner, 21000 * 3, "fee.tip"},
+		{ctypes.CELO_COINID, alice, gateway, 100, "fee.gateway"},
+		{7236, carol, governance, 50000 * 3, "fee.base"},
+		{7236, carol, miner, 50000 * 1, "fee.tip"},
+	}, got)
+
+	// A fee currency missing from the registry is an error.
+	p.tokens = nil
+	p.pullTxList = make(map[string][]*ctypes.TokenRecord)
+	require.Error(t, p.processFees(context.Background(), b, receipts, "tx_test"))
+}
@@ -0,0 +1,76 @@
+package transactions
+
+import (
+	"encoding/json"
+	"flag"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the fixture tests")
+
+// golden is the expected outcome of pulling the block of a fixture.
+type golden struct {
+	Block        uint64
+	Transactions []goldenTx
+	Records      []*ctypes.TokenRecord
+}
+
+type goldenTx struct {
+	Hash common.Hash
+	Type uint8
+}
+
+// TestFixtures replays the fixtures in testdata. Each <name>.jsonl holds the
+// calls recorded with client.DialRecord while pulling one block, usually from
+// mainnet, and <name>.golden.json the block number and the expected
+// transactions and records. Run with -update to rewrite the golden files
+// after an intended change.
+func TestFixtures(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "*.jsonl"))
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
+
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".jsonl")
+		t.Run(name, func(t *testing.T) {
+			goldenPath := filepath.Join("testdata", name+".golden.json")
+			enc, err := os.ReadFile(goldenPath)
+			require.NoError(t, err)
+			var want golden
+			require.NoError(t, json.Unmarshal(enc, &want))
+
+			cli, err := client.DialReplay(fixture)
+			require.NoError(t, err)
+			defer cli.Close()
+			p := &BlockPull{
+				client:     cli,
+				coinID:     big.NewInt(ctypes.CELO_COINID).String(),
+				pullTxList: make(map[string][]*ctypes.TokenRecord),
+			}
+
+			got := golden{Block: want.Block}
+			b, records := pullRecords(t, p, want.Block)
+			for _, tx := range b.Transactions() {
+				got.Transactions = append(got.Transactions, goldenTx{Hash: tx.Hash(), Type: tx.Type()})
+			}
+			got.Records = records
+
+			if *update {
+				enc, err := json.MarshalIndent(&got, "", "  ")
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(goldenPath, append(enc, '\n'), 0644))
+				return
+			}
+			require.Equal(t, want, got)
+		})
+	}
+}
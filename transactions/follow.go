@@ -0,0 +1,58 @@
+package transactions
+
+import (
+	"context"
+	"errors"
+
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/reorg"
+	"github.com/xuxinlai2002/creda-celo-balance/signal"
+)
+
+// follow keeps pulling new blocks once they have the configured number of
+// confirmations, driven by a newHeads subscription. After a reconnect it
+// resumes from the checkpoint.
+func (p *BlockPull) follow(interceptor signal.Interceptor, next uint64) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := client.Follow(ctx, p.config.WS, nil)
+	log.Infof("following chain head from %v", next)
+	for {
+		var ev client.FollowEvent
+		select {
+		case ev = <-events:
+		case <-interceptor.ShutdownChannel():
+			log.Infof("tx service shutting down...")
+			return nil
+		}
+
+		if ev.Connected {
+			if progress, ok, err := p.reorg.Checkpoint(); err == nil && ok && progress >= next {
+				next = progress + 1
+			}
+		}
+		if ev.Head == nil {
+			continue
+		}
+		head := ev.Head.Number.Uint64()
+		if head < p.config.Confirmations || head-p.config.Confirmations < next {
+			continue
+		}
+		confirmed := head - p.config.Confirmations
+
+		for next <= confirmed {
+			err := p.pullHeight(ctx, next)
+			var reorgErr *reorg.Error
+			if errors.As(err, &reorgErr) {
+				next = reorgErr.Ancestor + 1
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			next++
+		}
+		log.Infof("pulled blocks up to %v", confirmed)
+	}
+}
@@ -0,0 +1,91 @@
+package transactions
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/contracts/config"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+// The trace addresses of LockedGold records, keyed by the topic of the event
+// they record. Each of these events carries the account as its only indexed
+// argument and the amount as the first word of its data.
+var lockedGoldEvents = map[common.Hash]string{
+	crypto.Keccak256Hash([]byte("GoldLocked(address,uint256)")):           ctypes.GOLD_LOCKED,
+	crypto.Keccak256Hash([]byte("GoldUnlocked(address,uint256,uint256)")): ctypes.GOLD_UNLOCKED,
+	crypto.Keccak256Hash([]byte("GoldRelocked(address,uint256)")):         ctypes.GOLD_RELOCKED,
+	crypto.Keccak256Hash([]byte("GoldWithdrawn(address,uint256)")):        ctypes.GOLD_WITHDRAWN,
+}
+
+// accountSlashed is the topic of AccountSlashed(address indexed slashed,
+// uint256 penalty, address indexed reporter, uint256 reward). The penalty
+// leaves the locked CELO of the slashed account, the reward is locked for
+// the reporter and the rest is sent to the community fund.
+var accountSlashed = crypto.Keccak256Hash([]byte("AccountSlashed(address,uint256,address,uint256)"))
+
+// isLockedGoldEvent reports whether l has the layout of a LockedGold event
+// with a position record.
+func isLockedGoldEvent(l *types.Log) bool {
+	if len(l.Topics) == 3 && l.Topics[0] == accountSlashed {
+		return len(l.Data) >= 64
+	}
+	_, ok := lockedGoldEvents[l.Topics[0]]
+	return ok && len(l.Topics) == 2 && len(l.Data) >= 32
+}
+
+// processLockedGold records the LockedGold events of the transactions of b.
+// The CELO moving in and out of LockedGold is already traced; the records
+// keep the positions of the account inside it, from account to account so
+// they leave liquid balances alone. Voter rewards have no event per voter
+// and are recorded by attributeVoterRewards.
+func (p *BlockPull) processLockedGold(ctx context.Context, b *types.Block, receipts []*client.TxReceipt, filePath string) error {
+	var lockedGold *common.Address
+	for _, receipt := range receipts {
+		for _, l := range receipt.Logs {
+			if len(l.Topics) == 0 || !isLockedGoldEvent(l) {
+				continue
+			}
+			// Resolve LockedGold only for blocks that may use it.
+			if lockedGold == nil {
+				address, err := p.client.RegisteredAddress(ctx, config.LockedGoldRegistryId, new(big.Int).Sub(b.Number(), big.NewInt(1)))
+				if err != nil {
+					return err
+				}
+				lockedGold = &address
+			}
+			if l.Address != *lockedGold {
+				continue
+			}
+			if l.Topics[0] == accountSlashed {
+				p.addLockedGoldRecord(b, l, filePath, common.BytesToAddress(l.Topics[1].Bytes()), ctypes.GOLD_SLASHED, l.Data[:32])
+				p.addLockedGoldRecord(b, l, filePath, common.BytesToAddress(l.Topics[2].Bytes()), ctypes.GOLD_SLASH_REWARDED, l.Data[32:64])
+				continue
+			}
+			p.addLockedGoldRecord(b, l, filePath, common.BytesToAddress(l.Topics[1].Bytes()), lockedGoldEvents[l.Topics[0]], l.Data[:32])
+		}
+	}
+	return nil
+}
+
+// addLockedGoldRecord records the change name of the position of account by
+// the amount encoded in word, made by the event l.
+func (p *BlockPull) addLockedGoldRecord(b *types.Block, l *types.Log, filePath string, account common.Address, name string, word []byte) {
+	p.addPullTxRecord(filePath, &ctypes.TokenRecord{
+		CoinID:       ctypes.CELO_COINID,
+		BlockNumber:  b.NumberU64(),
+		Timestamp:    b.Time(),
+		TxHash:       l.TxHash,
+		TxIndex:      l.TxIndex,
+		From:         account,
+		To:           account,
+		Value:        new(big.Int).SetBytes(word),
+		LogIndex:     int(l.Index),
+		TraceAddress: name,
+		FrameType:    ctypes.LOCKED_GOLD_FRAME,
+	})
+}
@@ -0,0 +1,94 @@
+package transactions
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/contracts/config"
+	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/client/mocknode"
+	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+// lockedGoldEvent returns a log of contract with the event signature, account
+// as its first topic and values as its data.
+func lockedGoldEvent(contract common.Address, signature string, account common.Address, values ...int64) *mocknode.Log {
+	var data []byte
+	for _, v := range values {
+		data = append(data, common.LeftPadBytes(big.NewInt(v).Bytes(), 32)...)
+	}
+	return &mocknode.Log{
+		Address: contract,
+		Topics:  []common.Hash{crypto.Keccak256Hash([]byte(signature)), common.BytesToHash(account.Bytes())},
+		Data:    data,
+	}
+}
+
+// TestProcessLockedGold tests that the events of LockedGold become position
+// records of their accounts, a slashing one of the slashed account and one
+// of the reporter, and that lookalike events of other contracts are ignored.
+func TestProcessLockedGold(t *testing.T) {
+	var (
+		lockedGold = common.HexToAddress("0x0000000000000000000000000000000000001001")
+		impostor   = common.HexToAddress("0x0000000000000000000000000000000000001002")
+	)
+	slash := lockedGoldEvent(lockedGold, "AccountSlashed(address,uint256,address,uint256)", bob, 8)
+	slash.Topics = append(slash.Topics, common.BytesToHash(carol.Bytes()))
+	slash.Data = append(slash.Data, common.LeftPadBytes(big.NewInt(3).Bytes(), 32)...)
+
+	node := mocknode.New(1000)
+	defer node.Close()
+	node.SetRegistered(config.LockedGoldRegistryId, lockedGold)
+	node.AddBlock(1005)
+	node.AddBlock(1010,
+		&mocknode.Tx{From: alice, To: &lockedGold, Value: big.NewInt(100), Logs: []*mocknode.Log{
+			lockedGoldEvent(lockedGold, "GoldLocked(address,uint256)", alice, 100),
+		}},
+		&mocknode.Tx{From: bob, To: &lockedGold, Logs: []*mocknode.Log{
+			lockedGoldEvent(lockedGold, "GoldUnlocked(address,uint256,uint256)", bob, 40, 1700000000),
+			lockedGoldEvent(lockedGold, "GoldRelocked(address,uint256)", bob, 10),
+			lockedGoldEvent(impostor, "GoldWithdrawn(address,uint256)", bob, 30),
+		}},
+		&mocknode.Tx{From: carol, To: &lockedGold, Logs: []*mocknode.Log{
+			lockedGoldEvent(lockedGold, "GoldWithdrawn(address,uint256)", carol, 5),
+			slash,
+		}},
+	)
+
+	p := newTestPull(t, node)
+	ctx := context.Background()
+	// Blocks without LockedGold events do not resolve LockedGold.
+	for number := int64(1); number <= 2; number++ {
+		b, err := p.client.BlockByNumber(ctx, big.NewInt(number))
+		require.NoError(t, err)
+		receipts, err := p.receipts(ctx, b)
+		require.NoError(t, err)
+		require.NoError(t, p.processLockedGold(ctx, b, receipts, "tx_test"))
+		require.Equal(t, int(number-1), node.Calls("eth_call"))
+	}
+
+	type position struct {
+		account  common.Address
+		value    int64
+		name     string
+		logIndex int
+	}
+	var got []position
+	for _, r := range p.pullTxList["tx_test"] {
+		require.Equal(t, ctypes.LOCKED_GOLD_FRAME, r.FrameType)
+		require.Equal(t, uint64(ctypes.CELO_COINID), r.CoinID)
+		require.Equal(t, r.From, r.To)
+		got = append(got, position{r.From, r.Value.Int64(), r.TraceAddress, r.LogIndex})
+	}
+	require.Equal(t, []position{
+		{alice, 100, ctypes.GOLD_LOCKED, 0},
+		{bob, 40, ctypes.GOLD_UNLOCKED, 1},
+		{bob, 10, ctypes.GOLD_RELOCKED, 2},
+		{carol, 5, ctypes.GOLD_WITHDRAWN, 4},
+		{bob, 8, ctypes.GOLD_SLASHED, 5},
+		{carol, 3, ctypes.GOLD_SLASH_REWARDED, 5},
+	}, got)
+}
@@ -0,0 +1,112 @@
+package transactions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/contracts/config"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/celo-blockchain/crypto"
+	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+var (
+	transferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	// validatorPaymentTopic is emitted by Validators for the cUSD paid to a
+	// validator and its group.
+	validatorPaymentTopic = crypto.Keccak256Hash([]byte("ValidatorEpochPaymentDistributed(address,uint256,address,uint256)"))
+	// voterRewardsTopic is emitted by Election for the CELO added to the
+	// votes of a group.
+	voterRewardsTopic = crypto.Keccak256Hash([]byte("EpochRewardsDistributedToVoters(address,uint256)"))
+)
+
+// The trace addresses of reward records, naming the reward.
+const (
+	validatorRewardAddress = "reward.validator"
+	groupRewardAddress     = "reward.group"
+	voterRewardAddress     = "reward.voters"
+	fundRewardAddress      = "reward.fund"
+)
+
+// isEpochBlock reports whether number is the last block of an epoch, the one
+// paying the epoch rewards.
+func (p *BlockPull) isEpochBlock(number uint64) bool {
+	return p.epochSize > 0 && number > 0 && number%p.epochSize == 0
+}
+
+// processRewards records the epoch rewards paid by the system calls of epoch
+// block b. They are not transactions, so neither traces nor Transfer logs of
+// transactions show them. The records are the Transfer logs of tracked tokens
+// in the block receipt, named after the Validators and Election events:
+// cUSD minted for validators and groups, CELO minted to LockedGold for voters
+// and CELO minted for the community and carbon funds. The CELO minted to
+// LockedGold is its liquid balance; the share of each voter is recorded as
+// a LockedGold record by attributeVoterRewards.
+func (p *BlockPull) processRewards(ctx context.Context, b *types.Block, filePath string) error {
+	if !p.isEpochBlock(b.NumberU64()) {
+		return nil
+	}
+	receipt, err := p.client.BlockReceipt(ctx, b.Hash())
+	if err != nil {
+		return errors.New(fmt.Sprintf("block receipt of %v err: %v", b.NumberU64(), err))
+	}
+	lockedGold, err := p.client.RegisteredAddress(ctx, config.LockedGoldRegistryId, new(big.Int).Sub(b.Number(), big.NewInt(1)))
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[common.Address]bool)
+	voterRewards := big.NewInt(0)
+	for _, l := range receipt.Logs {
+		switch {
+		case len(l.Topics) == 3 && l.Topics[0] == validatorPaymentTopic:
+			groups[common.BytesToAddress(l.Topics[2].Bytes())] = true
+		case len(l.Topics) == 2 && l.Topics[0] == voterRewardsTopic:
+			voterRewards.Add(voterRewards, new(big.Int).SetBytes(l.Data))
+		}
+	}
+
+	mintedForVoters := big.NewInt(0)
+	for _, l := range receipt.Logs {
+		token, ok := p.tokens[l.Address]
+		if !ok || len(l.Topics) != 3 || l.Topics[0] != transferTopic {
+			continue
+		}
+		value := new(big.Int).SetBytes(l.Data)
+		if value.Sign() <= 0 {
+			continue
+		}
+		to := common.BytesToAddress(l.Topics[2].Bytes())
+		reward := fundRewardAddress
+		switch {
+		case groups[to]:
+			reward = groupRewardAddress
+		case token.CoinID != ctypes.CELO_COINID:
+			// Payments delegated by a validator go to its beneficiary.
+			reward = validatorRewardAddress
+		case to == lockedGold:
+			reward = voterRewardAddress
+			mintedForVoters.Add(mintedForVoters, value)
+		}
+		p.addPullTxRecord(filePath, &ctypes.TokenRecord{
+			CoinID:       token.CoinID,
+			BlockNumber:  b.NumberU64(),
+			Timestamp:    b.Time(),
+			TxHash:       l.TxHash,
+			TxIndex:      l.TxIndex,
+			From:         common.BytesToAddress(l.Topics[1].Bytes()),
+			To:           to,
+			Value:        value,
+			LogIndex:     int(l.Index),
+			TraceAddress: reward,
+			FrameType:    ctypes.REWARD_FRAME,
+		})
+	}
+	if mintedForVoters.Cmp(voterRewards) != 0 {
+		log.Warnf("epoch block %v distributed %v to voters but minted %v to LockedGold", b.NumberU64(), voterRewards, mintedForVoters)
+	}
+	return p.attributeVoterRewards(ctx, b, lockedGold, voterRewards, filePath)
+}
@@ -0,0 +1,93 @@
+package transactions
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/contracts/config"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/client/mocknode"
+	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+// TestProcessRewards tests that the token transfers of the system calls of
+// epoch blocks become reward records named after the reward.
+func TestProcessRewards(t *testing.T) {
+	var (
+		cUSD        = common.HexToAddress("0x765DE816845861e75A25fCA122bb6898B8B1282a")
+		goldToken   = common.HexToAddress("0x471EcE3750Da237f93B8E339c536989b8978a438")
+		validators  = common.HexToAddress("0x000000000000000000000000000000000000a11d")
+		election    = common.HexToAddress("0x000000000000000000000000000000000000e1ec")
+		lockedGold  = common.HexToAddress("0x0000000000000000000000000000000000001001")
+		fund        = common.HexToAddress("0x000000000000000000000000000000000000f00d")
+		validator   = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		group       = common.HexToAddress("0x0000000000000000000000000000000000000002")
+		beneficiary = common.HexToAddress("0x0000000000000000000000000000000000000003")
+	)
+	zero := common.ZeroAddress
+
+	node := mocknode.New(1000)
+	defer node.Close()
+	node.SetRegistered(config.LockedGoldRegistryId, lockedGold)
+	node.AddBlock(1005)
+	node.AddBlock(1010)
+	node.AddSystemLogs(1, mocknode.TransferLog(goldToken, zero, fund, big.NewInt(9)))
+	node.AddSystemLogs(2,
+		mocknode.TransferLog(cUSD, zero, group, big.NewInt(3)),
+		mocknode.TransferLog(cUSD, zero, validator, big.NewInt(10)),
+		mocknode.TransferLog(cUSD, zero, beneficiary, big.NewInt(2)),
+		&mocknode.Log{
+			Address: validators,
+			Topics: []common.Hash{validatorPaymentTopic,
+				common.BytesToHash(validator.Bytes()), common.BytesToHash(group.Bytes())},
+			Data: append(common.LeftPadBytes([]byte{10}, 32), common.LeftPadBytes([]byte{3}, 32)...),
+		},
+		mocknode.TransferLog(goldToken, zero, lockedGold, big.NewInt(50)),
+		&mocknode.Log{
+			Address: election,
+			Topics:  []common.Hash{voterRewardsTopic, common.BytesToHash(group.Bytes())},
+			Data:    common.LeftPadBytes([]byte{50}, 32),
+		},
+		mocknode.TransferLog(goldToken, zero, fund, big.NewInt(7)),
+	)
+
+	p := newTestPull(t, node)
+	p.epochSize = 2
+	p.tokens = map[common.Address]*ctypes.Token{
+		cUSD:      {Address: cUSD, CoinID: 7236},
+		goldToken: {Address: goldToken, CoinID: ctypes.CELO_COINID},
+	}
+	// No voter holds LockedGold, the voter rewards stay unattributed.
+	withOpenings(t, p, 2)
+
+	for number := int64(1); number <= 2; number++ {
+		b, err := p.client.BlockByNumber(context.Background(), big.NewInt(number))
+		require.NoError(t, err)
+		require.NoError(t, p.processRewards(context.Background(), b, "tx_test"))
+	}
+
+	type reward struct {
+		coinID uint64
+		to     common.Address
+		value  int64
+		name   string
+	}
+	var got []reward
+	for _, r := range p.pullTxList["tx_test"] {
+		require.Equal(t, ctypes.REWARD_FRAME, r.FrameType)
+		require.Equal(t, uint64(2), r.BlockNumber)
+		require.Equal(t, r.TxHash, node.Header(2).Hash())
+		require.Equal(t, zero, r.From)
+		got = append(got, reward{r.CoinID, r.To, r.Value.Int64(), r.TraceAddress})
+	}
+	require.Equal(t, []reward{
+		{7236, group, 3, "reward.group"},
+		{7236, validator, 10, "reward.validator"},
+		{7236, beneficiary, 2, "reward.validator"},
+		{ctypes.CELO_COINID, lockedGold, 50, "reward.voters"},
+		{ctypes.CELO_COINID, fund, 7, "reward.fund"},
+	}, got)
+	require.Equal(t, 1, node.Calls("eth_getBlockReceipt"))
+}
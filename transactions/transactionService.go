@@ -2,16 +2,24 @@ package transactions
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/big"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/celo-org/celo-blockchain/common"
 	"github.com/celo-org/celo-blockchain/common/hexutil"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/celo-blockchain/params"
+	"github.com/celo-org/celo-blockchain/rpc"
 	"github.com/xuxinlai2002/creda-celo-balance/client"
 	"github.com/xuxinlai2002/creda-celo-balance/config"
 	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/metadata"
+	"github.com/xuxinlai2002/creda-celo-balance/opening"
+	"github.com/xuxinlai2002/creda-celo-balance/reorg"
 	"github.com/xuxinlai2002/creda-celo-balance/signal"
 	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
 	"github.com/xuxinlai2002/creda-celo-balance/utils"
@@ -25,17 +33,44 @@ type InternalTx struct {
 	Type  string
 }
 
+// methodNotFoundCode is the JSON-RPC error code for an unsupported method.
+const methodNotFoundCode = -32601
+
+// valueFrames are the callTracer frame types that move their value from the
+// from to the to address. The value of a DELEGATECALL is the one of its
+// parent call and a CALLCODE sends value to its own caller.
+var valueFrames = map[string]bool{
+	"CALL":         true,
+	"CREATE":       true,
+	"CREATE2":      true,
+	"SELFDESTRUCT": true,
+}
+
+// indexerName names the checkpoint and recorded blocks of the tx indexer.
+const indexerName = "tx"
+
 type BlockPull struct {
 	client     *client.Client
 	config     *config.Config
 	coinID     string
 	pullTxList map[string][]*ctypes.TokenRecord
 	dataBase   *db.PostgresDB
+	reorg      *reorg.Detector
+	openings   *opening.Seeder
 	wg         *sync.WaitGroup
+
+	// tokens are the tracked tokens by address.
+	tokens      map[common.Address]*ctypes.Token
+	chainConfig *params.ChainConfig
+	// epochSize is the number of blocks per epoch, zero when unknown.
+	epochSize uint64
+
+	// disableBlockTrace is set once the node rejects debug_traceBlockByNumber.
+	disableBlockTrace bool
 }
 
 func New(cfg *config.Config, wg *sync.WaitGroup) (*BlockPull, error) {
-	cli, err := client.Dial(cfg.HTTP)
+	cli, err := client.DialConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -43,12 +78,38 @@ func New(cfg *config.Config, wg *sync.WaitGroup) (*BlockPull, error) {
 	if err != nil {
 		return nil, err
 	}
+	detector, err := reorg.New(cli, database, indexerName, "tx_")
+	if err != nil {
+		return nil, err
+	}
+	if err := utils.ImportCurrentHeight(database, indexerName); err != nil {
+		return nil, errors.New(fmt.Sprintf("import tx progress err: %v", err))
+	}
+	tokens, err := metadata.Load(cfg.TokenRegistry)
+	if err != nil {
+		return nil, err
+	}
+	chainID, err := cli.ChainID(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	seeder, err := opening.New(context.Background(), cfg, cli, database, tokens)
+	if err != nil {
+		return nil, err
+	}
 	pull := &BlockPull{
-		client:   cli,
-		config:   cfg,
-		coinID:   big.NewInt(ctypes.CELO_COINID).String(),
-		dataBase: database,
-		wg:       wg,
+		client:      cli,
+		config:      cfg,
+		coinID:      big.NewInt(ctypes.CELO_COINID).String(),
+		dataBase:    database,
+		reorg:       detector,
+		openings:    seeder,
+		wg:          wg,
+		tokens:      tokens,
+		chainConfig: chainConfig(chainID),
+	}
+	if pull.chainConfig.Istanbul != nil {
+		pull.epochSize = pull.chainConfig.Istanbul.Epoch
 	}
 	return pull, nil
 }
@@ -57,9 +118,11 @@ func (p *BlockPull) Start(interceptor signal.Interceptor) {
 	p.wg.Add(1)
 	go func() {
 		defer p.wg.Done()
-		p.pullBlock(interceptor)
-		p.persistToDB(p.pullTxList)
+		if err := p.pullBlock(interceptor); err != nil {
+			log.Errorf("pull block stopped: %v", err)
+		}
 		p.dataBase.Close()
+		p.client.Close()
 		log.Infof("tx service finished")
 	}()
 }
@@ -69,26 +132,14 @@ func (p *BlockPull) getTableNameByTimeStamp(timestamp uint64) string {
 	date := fmt.Sprintf("tx_%04d%02d%02d", t.Year(), int(t.Month()), t.Day())
 	return date
 }
-func (p *BlockPull) persistToDB(records map[string][]*ctypes.TokenRecord) {
-	for filename, datas := range records {
-		err := p.dataBase.CreateRecordTable(filename)
-		if err != nil {
-			log.Errorf("persistToDB CreateRecordTable err: %v", err)
-			panic(any(err.Error()))
-		}
-		err = p.dataBase.InsertRecords(filename, datas)
-		if err != nil {
-			log.Errorf("persistToDB failed, err: %v", err)
-			panic(any(err.Error()))
-		}
-	}
-}
-
 func (p *BlockPull) pullBlock(interceptor signal.Interceptor) error {
 	p.pullTxList = make(map[string][]*ctypes.TokenRecord)
 	startHeight := p.config.PullStartHeight
-	progress, err := utils.GetCurrentHeight()
-	if err == nil && progress > startHeight {
+	progress, ok, err := p.reorg.Checkpoint()
+	if err != nil {
+		return err
+	}
+	if ok && progress >= startHeight {
 		startHeight = progress + 1
 	}
 	endHeight := p.config.PullEndHeight
@@ -96,31 +147,15 @@ func (p *BlockPull) pullBlock(interceptor signal.Interceptor) error {
 	for i := startHeight; i <= endHeight; i++ {
 		select {
 		default:
-			b, err := p.client.BlockByNumber(ctx, big.NewInt(0).SetUint64(i))
+			err := p.pullHeight(ctx, i)
+			var reorgErr *reorg.Error
+			if errors.As(err, &reorgErr) {
+				i = reorgErr.Ancestor
+				continue
+			}
 			if err != nil {
 				return err
 			}
-			filePath := p.getTableNameByTimeStamp(b.Time())
-			if _, ok := p.pullTxList[filePath]; !ok {
-				if len(p.pullTxList) > 0 {
-					p.persistToDB(p.pullTxList)
-					p.pullTxList = make(map[string][]*ctypes.TokenRecord)
-				}
-			}
-			log.Infof("getBlock %v", b.NumberU64())
-			for _, tx := range b.Transactions() {
-				log.Infof("trace tx %v", tx.Hash())
-				info, err := p.client.TraceTx(ctx, tx.Hash().String())
-				if err != nil {
-					return err
-				}
-				if info["error"] != nil {
-					continue
-				}
-				p.processInteralTxsInfo(info, tx.Hash(), b.NumberU64(), b.Time(), filePath)
-
-			}
-			utils.WriteCurrentHeight(b.NumberU64())
 
 		case <-interceptor.ShutdownChannel():
 			log.Infof("tx service shutting down...")
@@ -128,9 +163,161 @@ func (p *BlockPull) pullBlock(interceptor signal.Interceptor) error {
 		}
 	}
 
+	if p.config.Follow {
+		next := endHeight + 1
+		if startHeight > next {
+			next = startHeight
+		}
+		return p.follow(interceptor, next)
+	}
+	return nil
+}
+
+// receipts fetches the receipts of the transactions of b.
+func (p *BlockPull) receipts(ctx context.Context, b *types.Block) ([]*client.TxReceipt, error) {
+	txs := b.Transactions()
+	hashes := make([]common.Hash, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.Hash()
+	}
+	receipts, errs := p.client.TxReceipts(ctx, hashes)
+	for i := range errs {
+		if errs[i] != nil {
+			return nil, errors.New(fmt.Sprintf("receipt of tx %v err: %v", hashes[i], errs[i]))
+		}
+	}
+	return receipts, nil
+}
+
+// pullHeight traces the block at the given height and commits its internal
+// CELO transfers, transaction fees, LockedGold events and epoch rewards
+// together with the checkpoint, once the accounts seen for the first time
+// are seeded. The opening positions of new LockedGold holders are committed
+// with them, so only committed holders count as known. If the block does not extend the pulled
+// chain, the records of the orphaned blocks are rolled back and a
+// *reorg.Error is returned.
+func (p *BlockPull) pullHeight(ctx context.Context, height uint64) error {
+	b, err := p.client.BlockByNumber(ctx, big.NewInt(0).SetUint64(height))
+	if err != nil {
+		return err
+	}
+	if err := p.reorg.Check(ctx, b.Header()); err != nil {
+		return err
+	}
+	filePath := p.getTableNameByTimeStamp(b.Time())
+	p.pullTxList = make(map[string][]*ctypes.TokenRecord)
+	log.Infof("getBlock %v", b.NumberU64())
+	infos, err := p.traceBlock(ctx, b)
+	if err != nil {
+		return err
+	}
+	for j, tx := range b.Transactions() {
+		p.processInteralTxsInfo(infos[j], tx.Hash(), uint(j), "", b.NumberU64(), b.Time(), filePath)
+	}
+	receipts, err := p.receipts(ctx, b)
+	if err != nil {
+		return err
+	}
+	if err := p.processFees(ctx, b, receipts, filePath); err != nil {
+		return err
+	}
+	if err := p.processLockedGold(ctx, b, receipts, filePath); err != nil {
+		return err
+	}
+	if err := p.backfillVoterRewards(ctx, b, filePath); err != nil {
+		return err
+	}
+	if err := p.processRewards(ctx, b, filePath); err != nil {
+		return err
+	}
+	if err := p.openings.Seed(ctx, p.pullTxList); err != nil {
+		return err
+	}
+	positions, err := p.openings.Positions(ctx, p.pullTxList)
+	if err != nil {
+		return err
+	}
+	if err := p.reorg.Commit(b.NumberU64(), p.pullTxList, positions, b.Header()); err != nil {
+		return err
+	}
+	p.openings.MarkPositioned(positions)
 	return nil
 }
 
+// traceBlock returns the callTracer output of every transaction in b, in
+// block order. It uses a single debug_traceBlockByNumber call and falls back
+// to tracing each transaction when the node rejects block tracing or the
+// result cannot be matched to the block's transactions.
+func (p *BlockPull) traceBlock(ctx context.Context, b *types.Block) ([]map[string]interface{}, error) {
+	txs := b.Transactions()
+	if len(txs) == 0 {
+		return nil, nil
+	}
+	if !p.disableBlockTrace {
+		infos, err := p.traceBlockByNumber(ctx, b)
+		if err == nil {
+			return infos, nil
+		}
+		var rpcErr rpc.Error
+		if errors.As(err, &rpcErr) && rpcErr.ErrorCode() == methodNotFoundCode {
+			log.Warnf("node does not support block tracing, using per-tx tracing: %v", err)
+			p.disableBlockTrace = true
+		} else {
+			log.Warnf("trace block %v failed, using per-tx tracing: %v", b.NumberU64(), err)
+		}
+	}
+
+	txHashes := make([]string, len(txs))
+	for i, tx := range txs {
+		txHashes[i] = tx.Hash().String()
+	}
+	log.Infof("trace %v txs", len(txHashes))
+	infos, errs := p.client.TraceTxs(ctx, txHashes)
+	for i := range errs {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+	}
+	return infos, nil
+}
+
+func (p *BlockPull) traceBlockByNumber(ctx context.Context, b *types.Block) ([]map[string]interface{}, error) {
+	txs := b.Transactions()
+	results, err := p.client.TraceBlock(ctx, b.Number())
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]map[string]interface{}, len(txs))
+	index := make(map[common.Hash]int, len(txs))
+	for i, tx := range txs {
+		index[tx.Hash()] = i
+	}
+	for i, res := range results {
+		if res.Error != "" {
+			return nil, fmt.Errorf("trace result %d: %s", i, res.Error)
+		}
+		j := i
+		if res.TxHash != (common.Hash{}) {
+			var ok bool
+			if j, ok = index[res.TxHash]; !ok {
+				// Not a block transaction, e.g. a system call trace.
+				continue
+			}
+		}
+		if j >= len(txs) {
+			return nil, fmt.Errorf("got %d traces for %d txs", len(results), len(txs))
+		}
+		infos[j] = res.Result
+	}
+	for i, info := range infos {
+		if info == nil {
+			return nil, fmt.Errorf("missing trace for tx %v", txs[i].Hash())
+		}
+	}
+	return infos, nil
+}
+
 func (p *BlockPull) addPullTxRecord(filePath string, tr *ctypes.TokenRecord) {
 	if _, ok := p.pullTxList[filePath]; !ok {
 		p.pullTxList[filePath] = make([]*ctypes.TokenRecord, 0)
@@ -138,7 +325,14 @@ func (p *BlockPull) addPullTxRecord(filePath string, tr *ctypes.TokenRecord) {
 	p.pullTxList[filePath] = append(p.pullTxList[filePath], tr)
 }
 
-func (p *BlockPull) processInteralTxsInfo(txInfo map[string]interface{}, txID common.Hash, blockHeight, timestamp uint64, filePath string) {
+// processInteralTxsInfo records the CELO moved by the call txInfo at
+// traceAddress in the call tree of transaction txID, and by its subcalls.
+// A reverted call moves nothing, neither do its subcalls, so a failed
+// transaction records no transfer at all.
+func (p *BlockPull) processInteralTxsInfo(txInfo map[string]interface{}, txID common.Hash, txIndex uint, traceAddress string, blockHeight, timestamp uint64, filePath string) {
+	if reverted(txInfo) {
+		return
+	}
 	var tx = &InternalTx{
 		From: txInfo["from"].(string),
 		To:   txInfo["to"].(string),
@@ -152,19 +346,28 @@ func (p *BlockPull) processInteralTxsInfo(txInfo map[string]interface{}, txID co
 		}
 		tx.Value = v
 	}
-	if tx.Value != nil && tx.Value.Cmp(big.NewInt(0)) > 0 && tx.Type == "CALL" {
+	if tx.Value != nil && tx.Value.Cmp(big.NewInt(0)) > 0 && valueFrames[tx.Type] {
 		coinID, ok := big.NewInt(0).SetString(p.coinID, 10)
 		if !ok {
 			log.Warnf("CoinID %v is not correct", p.coinID)
 		}
+		to := common.HexToAddress(tx.To)
+		if tx.Type == "SELFDESTRUCT" && to == common.HexToAddress(tx.From) {
+			// A contract naming itself the beneficiary burns its balance.
+			to = common.Address{}
+		}
 		tr := &ctypes.TokenRecord{
-			CoinID:      coinID.Uint64(),
-			BlockNumber: blockHeight,
-			Timestamp:   timestamp,
-			TxHash:      txID,
-			From:        common.HexToAddress(tx.From),
-			To:          common.HexToAddress(tx.To),
-			Value:       tx.Value,
+			CoinID:       coinID.Uint64(),
+			BlockNumber:  blockHeight,
+			Timestamp:    timestamp,
+			TxHash:       txID,
+			TxIndex:      txIndex,
+			From:         common.HexToAddress(tx.From),
+			To:           to,
+			Value:        tx.Value,
+			LogIndex:     -1,
+			TraceAddress: traceAddress,
+			FrameType:    tx.Type,
 		}
 		p.addPullTxRecord(filePath, tr)
 	}
@@ -172,7 +375,23 @@ func (p *BlockPull) processInteralTxsInfo(txInfo map[string]interface{}, txID co
 	if calls, ok := txInfo["calls"]; ok {
 		var items = calls.([]interface{})
 		for i := 0; i < len(items); i++ {
-			p.processInteralTxsInfo(items[i].(map[string]interface{}), txID, blockHeight, timestamp, filePath)
+			p.processInteralTxsInfo(items[i].(map[string]interface{}), txID, txIndex, childTraceAddress(traceAddress, i), blockHeight, timestamp, filePath)
 		}
 	}
 }
+
+// reverted reports whether the callTracer frame failed, which undoes its
+// state changes including those of its subcalls.
+func reverted(txInfo map[string]interface{}) bool {
+	e, ok := txInfo["error"]
+	return ok && e != nil && e != ""
+}
+
+// childTraceAddress returns the trace address of the i-th call made by the
+// call at parent.
+func childTraceAddress(parent string, i int) string {
+	if parent == "" {
+		return strconv.Itoa(i)
+	}
+	return parent + "." + strconv.Itoa(i)
+}
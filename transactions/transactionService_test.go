@@ -0,0 +1,176 @@
+package transactions
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/client"
+	"github.com/xuxinlai2002/creda-celo-balance/client/mocknode"
+	"github.com/xuxinlai2002/creda-celo-balance/config"
+	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/db/dbtest"
+	"github.com/xuxinlai2002/creda-celo-balance/opening"
+	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+var (
+	alice    = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	bob      = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+	carol    = common.HexToAddress("0x00000000000000000000000000000000000ca201")
+	contract = common.HexToAddress("0x000000000000000000000000000000000000c0de")
+)
+
+func newTestPull(t *testing.T, node *mocknode.Node) *BlockPull {
+	cli, err := client.Dial(node.URL)
+	require.NoError(t, err)
+	t.Cleanup(cli.Close)
+	return &BlockPull{
+		client:     cli,
+		coinID:     big.NewInt(ctypes.CELO_COINID).String(),
+		pullTxList: make(map[string][]*ctypes.TokenRecord),
+	}
+}
+
+// withOpenings gives p a seeder of opening balances on the database
+// stand-in, for an indexer starting at start, and returns the database.
+func withOpenings(t *testing.T, p *BlockPull, start uint64) *db.PostgresDB {
+	database, err := db.NewDB("creda", "", "", dbtest.Use(t), 5432)
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	p.config = &config.Config{PullStartHeight: start}
+	p.openings, err = opening.New(context.Background(), p.config, p.client, database, p.tokens)
+	require.NoError(t, err)
+	return database
+}
+
+// pullRecords traces the block at number and records its transfers the way
+// pullHeight does, without touching the database.
+func pullRecords(t *testing.T, p *BlockPull, number uint64) (*types.Block, []*ctypes.TokenRecord) {
+	ctx := context.Background()
+	b, err := p.client.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+	require.NoError(t, err)
+	infos, err := p.traceBlock(ctx, b)
+	require.NoError(t, err)
+	for i, tx := range b.Transactions() {
+		p.processInteralTxsInfo(infos[i], tx.Hash(), uint(i), "", b.NumberU64(), b.Time(), "tx_test")
+	}
+	return b, p.pullTxList["tx_test"]
+}
+
+// scriptBlock adds a block with a plain transfer, a contract call moving
+// value internally and a failed transaction.
+func scriptBlock(node *mocknode.Node) (plain, nested, failed *mocknode.Tx) {
+	plain = &mocknode.Tx{From: alice, To: &bob, Value: big.NewInt(5)}
+	nested = &mocknode.Tx{
+		From:  alice,
+		To:    &contract,
+		Value: big.NewInt(10),
+		Trace: &mocknode.Frame{
+			Type: "CALL", From: alice, To: contract, Value: big.NewInt(10),
+			Calls: []*mocknode.Frame{
+				{Type: "CALL", From: contract, To: carol, Value: big.NewInt(4)},
+				{Type: "STATICCALL", From: contract, To: bob},
+			},
+		},
+	}
+	failed = &mocknode.Tx{
+		From:  bob,
+		To:    &carol,
+		Value: big.NewInt(3),
+		Trace: &mocknode.Frame{Type: "CALL", From: bob, To: carol, Value: big.NewInt(3), Error: "execution reverted"},
+	}
+	node.AddBlock(1005, plain, nested, failed)
+	return plain, nested, failed
+}
+
+// TestPullTransfers tests that traced value transfers become records, both
+// with block tracing and with the per-tx fallback.
+func TestPullTransfers(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		configure func(*mocknode.Node)
+	}{
+		{"block trace", func(*mocknode.Node) {}},
+		{"block trace without hashes", func(n *mocknode.Node) { n.OmitTraceTxHash = true }},
+		{"per-tx fallback", func(n *mocknode.Node) { n.NoBlockTrace = true }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			node := mocknode.New(1000)
+			defer node.Close()
+			tc.configure(node)
+			plain, nested, _ := scriptBlock(node)
+			p := newTestPull(t, node)
+
+			_, records := pullRecords(t, p, 1)
+			require.Len(t, records, 3)
+			require.Equal(t, plain.Hash(), records[0].TxHash)
+			require.Equal(t, alice, records[0].From)
+			require.Equal(t, bob, records[0].To)
+			require.Equal(t, big.NewInt(5), records[0].Value)
+			require.Equal(t, nested.Hash(), records[1].TxHash)
+			require.Equal(t, big.NewInt(10), records[1].Value)
+			require.Equal(t, nested.Hash(), records[2].TxHash)
+			require.Equal(t, contract, records[2].From)
+			require.Equal(t, carol, records[2].To)
+			require.Equal(t, []string{"", "", "0"}, []string{records[0].TraceAddress, records[1].TraceAddress, records[2].TraceAddress})
+			require.Equal(t, []uint{0, 1, 1}, []uint{records[0].TxIndex, records[1].TxIndex, records[2].TxIndex})
+			for _, r := range records {
+				require.Equal(t, uint64(ctypes.CELO_COINID), r.CoinID)
+				require.Equal(t, uint64(1005), r.Timestamp)
+				require.Equal(t, -1, r.LogIndex)
+			}
+
+			if node.NoBlockTrace {
+				require.True(t, p.disableBlockTrace)
+				require.Equal(t, 3, node.Calls("debug_traceTransaction"))
+			} else {
+				require.Equal(t, 0, node.Calls("debug_traceTransaction"))
+			}
+		})
+	}
+}
+
+// TestRevertedFrames tests that no value moved inside a reverted frame, or
+// by any frame of a failed transaction, is recorded.
+func TestRevertedFrames(t *testing.T) {
+	node := mocknode.New(1000)
+	defer node.Close()
+	partial := &mocknode.Tx{
+		From:  alice,
+		To:    &contract,
+		Value: big.NewInt(10),
+		Trace: &mocknode.Frame{
+			Type: "CALL", From: alice, To: contract, Value: big.NewInt(10),
+			Calls: []*mocknode.Frame{
+				{
+					Type: "CALL", From: contract, To: bob, Value: big.NewInt(4), Error: "execution reverted",
+					Calls: []*mocknode.Frame{{Type: "CALL", From: bob, To: carol, Value: big.NewInt(2)}},
+				},
+				{Type: "CALL", From: contract, To: carol, Value: big.NewInt(1)},
+			},
+		},
+	}
+	failed := &mocknode.Tx{
+		From:  alice,
+		To:    &contract,
+		Value: big.NewInt(7),
+		Trace: &mocknode.Frame{
+			Type: "CALL", From: alice, To: contract, Value: big.NewInt(7), Error: "out of gas",
+			Calls: []*mocknode.Frame{{Type: "CALL", From: contract, To: bob, Value: big.NewInt(7)}},
+		},
+	}
+	node.AddBlock(1005, partial, failed)
+	p := newTestPull(t, node)
+
+	_, records := pullRecords(t, p, 1)
+	require.Len(t, records, 2)
+	require.Equal(t, big.NewInt(10), records[0].Value)
+	require.Equal(t, "", records[0].TraceAddress)
+	require.Equal(t, carol, records[1].To)
+	require.Equal(t, big.NewInt(1), records[1].Value)
+	require.Equal(t, "1", records[1].TraceAddress)
+}
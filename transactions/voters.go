@@ -0,0 +1,173 @@
+package transactions
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/contracts/config"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/xuxinlai2002/creda-celo-balance/opening"
+	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+// lockedGoldChanges returns how much the LockedGold records of block number
+// change the CELO each of their accounts has locked. Withdrawals only change
+// pending CELO and show as accounts without change.
+func (p *BlockPull) lockedGoldChanges(filePath string, number uint64) map[common.Address]*big.Int {
+	changes := make(map[common.Address]*big.Int)
+	for _, r := range p.pullTxList[filePath] {
+		if r.FrameType != ctypes.LOCKED_GOLD_FRAME || r.BlockNumber != number {
+			continue
+		}
+		change := changes[r.From]
+		if change == nil {
+			change = big.NewInt(0)
+			changes[r.From] = change
+		}
+		switch r.TraceAddress {
+		case ctypes.GOLD_LOCKED, ctypes.GOLD_RELOCKED, ctypes.GOLD_SLASH_REWARDED:
+			change.Add(change, r.Value)
+		case ctypes.GOLD_UNLOCKED, ctypes.GOLD_SLASHED:
+			change.Sub(change, r.Value)
+		}
+	}
+	return changes
+}
+
+// backfillVoterRewards records the rewards of the epochs between the block
+// the indexer builds on and b of the accounts first recorded in b. Without a
+// LockedGold event in between, the growth of their locked CELO at each of
+// those epoch blocks is their reward.
+func (p *BlockPull) backfillVoterRewards(ctx context.Context, b *types.Block, filePath string) error {
+	if p.epochSize == 0 {
+		return nil
+	}
+	seed := opening.SeedBlock(p.config.PullStartHeight)
+	first := (seed/p.epochSize + 1) * p.epochSize
+	if first >= b.NumberU64() {
+		return nil
+	}
+	fresh := make([]common.Address, 0)
+	for account := range p.lockedGoldChanges(filePath, b.NumberU64()) {
+		if !p.openings.Positioned(account) {
+			fresh = append(fresh, account)
+		}
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+	sort.Slice(fresh, func(i, j int) bool { return bytes.Compare(fresh[i][:], fresh[j][:]) < 0 })
+
+	lockedGold, err := p.client.RegisteredAddress(ctx, config.LockedGoldRegistryId, new(big.Int).Sub(b.Number(), big.NewInt(1)))
+	if err != nil || lockedGold == (common.Address{}) {
+		return err
+	}
+	previous, err := p.totalLockedGold(ctx, lockedGold, fresh, seed)
+	if err != nil {
+		return err
+	}
+	for number := first; number < b.NumberU64(); number += p.epochSize {
+		totals, err := p.totalLockedGold(ctx, lockedGold, fresh, number)
+		if err != nil {
+			return err
+		}
+		header, err := p.client.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			return err
+		}
+		p.addVoterRewards(header, fresh, previous, totals, nil)
+		previous = totals
+	}
+	log.Debugf("backfilled voter rewards of %d accounts first recorded at %v", len(fresh), b.NumberU64())
+	return nil
+}
+
+// attributeVoterRewards records the epoch rewards of voters at epoch block b.
+// Election adds them to the votes of groups without an event per voter, so
+// they show as growth of the CELO a voter has locked not explained by its
+// LockedGold events in b. Only the recorded LockedGold holders have a
+// position to add it to, what is left of distributed is logged.
+func (p *BlockPull) attributeVoterRewards(ctx context.Context, b *types.Block, lockedGold common.Address, distributed *big.Int, filePath string) error {
+	if lockedGold == (common.Address{}) {
+		return nil
+	}
+	changes := p.lockedGoldChanges(filePath, b.NumberU64())
+	holders := p.openings.Holders()
+	for account := range changes {
+		if !p.openings.Positioned(account) {
+			holders = append(holders, account)
+		}
+	}
+	attributed := big.NewInt(0)
+	if len(holders) > 0 {
+		sort.Slice(holders, func(i, j int) bool { return bytes.Compare(holders[i][:], holders[j][:]) < 0 })
+		before, err := p.totalLockedGold(ctx, lockedGold, holders, b.NumberU64()-1)
+		if err != nil {
+			return err
+		}
+		after, err := p.totalLockedGold(ctx, lockedGold, holders, b.NumberU64())
+		if err != nil {
+			return err
+		}
+		attributed = p.addVoterRewards(b.Header(), holders, before, after, changes)
+	}
+
+	switch remainder := new(big.Int).Sub(distributed, attributed); remainder.Sign() {
+	case 1:
+		log.Infof("epoch block %v rewarded voters with %v, %v of it to voters never recorded", b.NumberU64(), distributed, remainder)
+	case -1:
+		log.Warnf("epoch block %v rewarded voters with %v but the recorded voters gained %v", b.NumberU64(), distributed, attributed)
+	}
+	return nil
+}
+
+// addVoterRewards records the growth of the CELO locked by accounts at the
+// epoch block header not explained by changes as their rewards, and returns
+// the total.
+func (p *BlockPull) addVoterRewards(header *types.Header, accounts []common.Address, before, after []*big.Int, changes map[common.Address]*big.Int) *big.Int {
+	total := big.NewInt(0)
+	filePath := p.getTableNameByTimeStamp(header.Time)
+	for i, account := range accounts {
+		reward := new(big.Int).Sub(after[i], before[i])
+		if change := changes[account]; change != nil {
+			reward.Sub(reward, change)
+		}
+		if reward.Sign() < 0 {
+			log.Warnf("locked CELO of %v dropped by %v at epoch block %v without a LockedGold event", account, reward.Neg(reward), header.Number)
+			continue
+		}
+		if reward.Sign() == 0 {
+			continue
+		}
+		total.Add(total, reward)
+		p.addPullTxRecord(filePath, &ctypes.TokenRecord{
+			CoinID:       ctypes.CELO_COINID,
+			BlockNumber:  header.Number.Uint64(),
+			Timestamp:    header.Time,
+			TxHash:       header.Hash(),
+			From:         account,
+			To:           account,
+			Value:        reward,
+			LogIndex:     -1,
+			TraceAddress: ctypes.GOLD_REWARDED + "." + account.Hex(),
+			FrameType:    ctypes.LOCKED_GOLD_FRAME,
+		})
+	}
+	return total
+}
+
+// totalLockedGold returns the CELO locked by accounts at block number.
+func (p *BlockPull) totalLockedGold(ctx context.Context, lockedGold common.Address, accounts []common.Address, number uint64) ([]*big.Int, error) {
+	totals, errs := p.client.TotalLockedGoldAt(ctx, lockedGold, accounts, new(big.Int).SetUint64(number))
+	for i := range errs {
+		if errs[i] != nil {
+			return nil, errors.New(fmt.Sprintf("locked CELO of %v at %v err: %v", accounts[i], number, errs[i]))
+		}
+	}
+	return totals, nil
+}
@@ -0,0 +1,175 @@
+package transactions
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common"
+	registry "github.com/celo-org/celo-blockchain/contracts/config"
+	"github.com/celo-org/celo-blockchain/params"
+	"github.com/stretchr/testify/require"
+	"github.com/xuxinlai2002/creda-celo-balance/client/mocknode"
+	"github.com/xuxinlai2002/creda-celo-balance/config"
+	"github.com/xuxinlai2002/creda-celo-balance/db"
+	"github.com/xuxinlai2002/creda-celo-balance/db/dbtest"
+	"github.com/xuxinlai2002/creda-celo-balance/opening"
+	"github.com/xuxinlai2002/creda-celo-balance/reorg"
+	ctypes "github.com/xuxinlai2002/creda-celo-balance/types"
+)
+
+// TestVoterRewards tests that the growth of the CELO locked by LockedGold
+// holders at epoch blocks, less their events, becomes reward records, and
+// that accounts first recorded later get the rewards of the epochs since the
+// indexer started.
+func TestVoterRewards(t *testing.T) {
+	var (
+		goldToken  = common.HexToAddress("0x471EcE3750Da237f93B8E339c536989b8978a438")
+		lockedGold = common.HexToAddress("0x0000000000000000000000000000000000001001")
+		election   = common.HexToAddress("0x000000000000000000000000000000000000e1ec")
+		group      = common.HexToAddress("0x0000000000000000000000000000000000000002")
+	)
+	zero := common.ZeroAddress
+
+	node := mocknode.New(1000)
+	defer node.Close()
+	node.ChainID = 1337
+	node.SetRegistered(registry.LockedGoldRegistryId, lockedGold)
+	node.AddBlock(1005)
+	node.AddBlock(1010)
+	node.AddBlock(1015, &mocknode.Tx{From: bob, To: &lockedGold, Logs: []*mocknode.Log{
+		lockedGoldEvent(lockedGold, "GoldUnlocked(address,uint256,uint256)", bob, 5, 1700000000),
+	}})
+	node.AddBlock(1020, &mocknode.Tx{From: alice, To: &lockedGold, Value: big.NewInt(10), Logs: []*mocknode.Log{
+		lockedGoldEvent(lockedGold, "GoldLocked(address,uint256)", alice, 10),
+	}})
+	node.AddSystemLogs(4,
+		mocknode.TransferLog(goldToken, zero, lockedGold, big.NewInt(12)),
+		&mocknode.Log{
+			Address: election,
+			Topics:  []common.Hash{voterRewardsTopic, common.BytesToHash(group.Bytes())},
+			Data:    common.LeftPadBytes([]byte{12}, 32),
+		},
+	)
+	// bob gains 2 at epoch 2 and 3 at epoch 4, alice 3 and 4, carol nothing.
+	node.SetLockedGold(lockedGold, bob, 0, big.NewInt(50))
+	node.SetLockedGold(lockedGold, bob, 2, big.NewInt(52))
+	node.SetLockedGold(lockedGold, bob, 3, big.NewInt(47))
+	node.SetLockedGold(lockedGold, bob, 4, big.NewInt(50))
+	node.SetLockedGold(lockedGold, alice, 0, big.NewInt(100))
+	node.SetLockedGold(lockedGold, alice, 2, big.NewInt(103))
+	node.SetLockedGold(lockedGold, alice, 4, big.NewInt(117))
+	node.SetLockedGold(lockedGold, carol, 0, big.NewInt(20))
+
+	p := newTestPull(t, node)
+	p.epochSize = 2
+	p.tokens = map[common.Address]*ctypes.Token{goldToken: {Address: goldToken, CoinID: ctypes.CELO_COINID}}
+	withOpenings(t, p, 0)
+	ctx := context.Background()
+	positions, err := p.openings.Positions(ctx, map[string][]*ctypes.TokenRecord{
+		"tx_test": {{From: carol, To: carol, TraceAddress: ctypes.GOLD_LOCKED, FrameType: ctypes.LOCKED_GOLD_FRAME}},
+	})
+	require.NoError(t, err)
+	p.openings.MarkPositioned(positions)
+
+	var filePath string
+	for number := int64(3); number <= 4; number++ {
+		b, err := p.client.BlockByNumber(ctx, big.NewInt(number))
+		require.NoError(t, err)
+		filePath = p.getTableNameByTimeStamp(b.Time())
+		receipts, err := p.receipts(ctx, b)
+		require.NoError(t, err)
+		require.NoError(t, p.processLockedGold(ctx, b, receipts, filePath))
+		require.NoError(t, p.backfillVoterRewards(ctx, b, filePath))
+		require.NoError(t, p.processRewards(ctx, b, filePath))
+		positions, err := p.openings.Positions(ctx, p.pullTxList)
+		require.NoError(t, err)
+		p.openings.MarkPositioned(positions)
+	}
+
+	type reward struct {
+		number  uint64
+		account common.Address
+		value   int64
+	}
+	var got []reward
+	for _, r := range p.pullTxList[filePath] {
+		if r.FrameType != ctypes.LOCKED_GOLD_FRAME || r.TraceAddress == ctypes.GOLD_LOCKED || r.TraceAddress == ctypes.GOLD_UNLOCKED {
+			continue
+		}
+		require.Equal(t, ctypes.GOLD_REWARDED+"."+r.From.Hex(), r.TraceAddress)
+		require.Equal(t, r.From, r.To)
+		require.Equal(t, node.Header(r.BlockNumber).Hash(), r.TxHash)
+		got = append(got, reward{r.BlockNumber, r.From, r.Value.Int64()})
+	}
+	require.Equal(t, []reward{
+		{2, bob, 2},
+		{2, alice, 3},
+		{4, bob, 3},
+		{4, alice, 4},
+	}, got)
+}
+
+// TestVoterRewardsAfterFailedCommit tests that a holder first recorded in a
+// block whose commit fails is still new when the block is pulled again, so
+// its rewards of the earlier epochs are recorded.
+func TestVoterRewardsAfterFailedCommit(t *testing.T) {
+	lockedGold := common.HexToAddress("0x0000000000000000000000000000000000001001")
+	node := mocknode.New(1000)
+	defer node.Close()
+	node.ChainID = 1337
+	node.SetRegistered(registry.LockedGoldRegistryId, lockedGold)
+	node.AddBlock(1005)
+	node.AddBlock(1010)
+	node.AddBlock(1015, &mocknode.Tx{From: alice, To: &lockedGold, Value: big.NewInt(10), Logs: []*mocknode.Log{
+		lockedGoldEvent(lockedGold, "GoldLocked(address,uint256)", alice, 10),
+	}})
+	node.SetLockedGold(lockedGold, alice, 0, big.NewInt(100))
+	node.SetLockedGold(lockedGold, alice, 2, big.NewInt(103))
+	node.SetLockedGold(lockedGold, alice, 3, big.NewInt(113))
+
+	host := dbtest.Use(t)
+	database, err := db.NewDB("creda", "", "", host, 5432)
+	require.NoError(t, err)
+	defer database.Close()
+	conn, err := sql.Open(db.Driver, "dbname=creda host="+host)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	p := newTestPull(t, node)
+	p.epochSize = 2
+	p.chainConfig = params.MainnetChainConfig
+	p.config = &config.Config{}
+	p.reorg, err = reorg.New(p.client, database, indexerName, "tx_")
+	require.NoError(t, err)
+	p.openings, err = opening.New(context.Background(), p.config, p.client, database, nil)
+	require.NoError(t, err)
+
+	_, err = conn.Exec("CREATE TRIGGER fail_checkpoint BEFORE INSERT ON indexer_checkpoints " +
+		"BEGIN SELECT RAISE(ABORT, 'disk full'); END")
+	require.NoError(t, err)
+	require.ErrorContains(t, p.pullHeight(context.Background(), 3), "disk full")
+	positions, err := database.ReadOpeningPositions()
+	require.NoError(t, err)
+	require.Empty(t, positions)
+
+	_, err = conn.Exec("DROP TRIGGER fail_checkpoint")
+	require.NoError(t, err)
+	require.NoError(t, p.pullHeight(context.Background(), 3))
+
+	records, err := database.ReadPullTxHistory(time.Unix(1015, 0))
+	require.NoError(t, err)
+	var rewards []string
+	for _, r := range records {
+		if r.TraceAddress == ctypes.GOLD_REWARDED+"."+alice.Hex() {
+			rewards = append(rewards, fmt.Sprintf("%d %v", r.BlockNumber, r.Value))
+		}
+	}
+	require.Equal(t, []string{"2 3"}, rewards)
+	positions, err = database.ReadOpeningPositions()
+	require.NoError(t, err)
+	require.Equal(t, []*ctypes.Position{{BlockNumber: 0, Address: alice, Locked: big.NewInt(100), Pending: big.NewInt(0)}}, positions)
+}
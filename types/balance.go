@@ -0,0 +1,17 @@
+package types
+
+import (
+	"math/big"
+
+	"github.com/celo-org/celo-blockchain/common"
+)
+
+// Balance is the balance of an account in a token at a block, as read from
+// the chain.
+type Balance struct {
+	BlockNumber uint64
+	Address     common.Address
+	Token       common.Address // Zero address for native CELO
+	CoinID      uint64
+	Value       *big.Int
+}
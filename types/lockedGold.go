@@ -0,0 +1,43 @@
+package types
+
+import (
+	"math/big"
+
+	"github.com/celo-org/celo-blockchain/common"
+)
+
+// The trace addresses of LOCKED_GOLD_FRAME records, naming the event.
+const (
+	GOLD_LOCKED    = "lockedgold.locked"
+	GOLD_UNLOCKED  = "lockedgold.unlocked"
+	GOLD_RELOCKED  = "lockedgold.relocked"
+	GOLD_WITHDRAWN = "lockedgold.withdrawn"
+	// An account slashed loses the penalty, the reporter gains the reward.
+	GOLD_SLASHED        = "lockedgold.slashed"
+	GOLD_SLASH_REWARDED = "lockedgold.slashrewarded"
+	// Epoch rewards of a voter, added to its votes. Several voters are
+	// rewarded by the same system call, so the trace address names the
+	// voter after the prefix, e.g. "lockedgold.rewarded.0x...".
+	GOLD_REWARDED = "lockedgold.rewarded"
+)
+
+// LockedGold is the CELO an account holds in the LockedGold contract, which
+// counts towards its net worth but not its liquid balance.
+type LockedGold struct {
+	Locked  *big.Int // Locked, e.g. to vote
+	Pending *big.Int // Unlocked and waiting to be withdrawn
+}
+
+// NewLockedGold returns an empty position.
+func NewLockedGold() *LockedGold {
+	return &LockedGold{Locked: big.NewInt(0), Pending: big.NewInt(0)}
+}
+
+// Position is the LockedGold position of an account at a block, as read from
+// the chain.
+type Position struct {
+	BlockNumber uint64
+	Address     common.Address
+	Locked      *big.Int
+	Pending     *big.Int
+}
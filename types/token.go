@@ -0,0 +1,16 @@
+package types
+
+import "github.com/celo-org/celo-blockchain/common"
+
+// CELO_DECIMALS are the decimals of native CELO.
+const CELO_DECIMALS = 18
+
+// Token is the metadata of a tracked ERC20 token.
+type Token struct {
+	Address    common.Address
+	Name       string // Configured display name
+	Symbol     string // As returned by the contract, empty if unknown
+	CoinID     uint64
+	Decimals   uint8
+	StartBlock uint64 // First block to pull transfers of the token from
+}
@@ -8,16 +8,47 @@ import (
 
 const CELO_COINID = 5567
 
+// The frame types of records not made by a call frame.
+const (
+	FEE_FRAME         = "FEE"        // transaction fees
+	REWARD_FRAME      = "REWARD"     // transfers made when paying epoch rewards
+	LOCKED_GOLD_FRAME = "LOCKEDGOLD" // changes of LockedGold positions
+)
+
 type TokenRecord struct {
 	CoinID      uint64
 	BlockNumber uint64
 	Timestamp   uint64
 	TxHash      common.Hash
+	TxIndex     uint
 	From        common.Address
 	To          common.Address
 	Value       *big.Int
+
+	// LogIndex is the index of the Transfer log in its block, -1 for
+	// transfers of internal calls.
+	LogIndex int
+	// TraceAddress is the position of an internal call in the call tree of
+	// its transaction, "" for the top-level call and e.g. "0.1" for the
+	// second call made by the first one. Fee records use "fee.base",
+	// "fee.tip" and "fee.gateway", reward records name the reward, e.g.
+	// "reward.validator", and LockedGold records the event, e.g.
+	// "lockedgold.unlocked".
+	TraceAddress string
+	// FrameType is the callTracer frame type of an internal transfer, e.g.
+	// "CALL", "CREATE2" or "SELFDESTRUCT", FEE_FRAME for fees, REWARD_FRAME
+	// for epoch rewards, LOCKED_GOLD_FRAME for LockedGold events and "" for
+	// Transfer logs.
+	FrameType string
 }
 
 type COINID uint64
 type ADDRESS string
 type DATE string
+
+// BlockRef identifies an indexed block and its parent.
+type BlockRef struct {
+	Number     uint64
+	Hash       common.Hash
+	ParentHash common.Hash
+}
@@ -6,13 +6,16 @@ import (
 	"os"
 )
 
-const PullProgressFile = "progress.txt"
-
-func WriteCurrentHeight(height uint64) error {
+// The progress files of the indexers before checkpoints moved to Postgres.
+const (
+	PullProgressFile  = "progress.txt"
+	tokenProgressFile = "tokenProgress.txt"
+)
 
-	content := big.NewInt(0).SetUint64(height)
-	err := os.WriteFile(PullProgressFile, content.Bytes(), 0666)
-	return err
+// Checkpointer stores the checkpoints of the indexers.
+type Checkpointer interface {
+	GetCheckpoint(indexer string) (uint64, bool, error)
+	SetCheckpoint(indexer string, height uint64) error
 }
 
 func GetCurrentHeight() (uint64, error) {
@@ -24,13 +27,6 @@ func GetCurrentHeight() (uint64, error) {
 	return v.Uint64(), nil
 }
 
-const tokenProgressFile = "tokenProgress.txt"
-
-func WriteTokenCurrentHeight(height uint64) error {
-	str := big.NewInt(0).SetUint64(height).String()
-	return os.WriteFile(tokenProgressFile, []byte(str), 0666)
-}
-
 func GetTokenCurrentHeight() (uint64, error) {
 	data, err := os.ReadFile(tokenProgressFile)
 	if err != nil {
@@ -44,3 +40,37 @@ func GetTokenCurrentHeight() (uint64, error) {
 
 	return v.Uint64(), nil
 }
+
+// ImportCurrentHeight moves the progress file of the transaction indexer into
+// the checkpoint of indexer.
+func ImportCurrentHeight(c Checkpointer, indexer string) error {
+	return importProgress(c, indexer, PullProgressFile, GetCurrentHeight)
+}
+
+// ImportTokenCurrentHeight moves the progress file of the token indexer into
+// the checkpoint of indexer.
+func ImportTokenCurrentHeight(c Checkpointer, indexer string) error {
+	return importProgress(c, indexer, tokenProgressFile, GetTokenCurrentHeight)
+}
+
+// importProgress sets the checkpoint of indexer to the height in file unless
+// it already has one, then renames file so that it is imported only once.
+func importProgress(c Checkpointer, indexer, file string, read func() (uint64, error)) error {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil
+	}
+	_, ok, err := c.GetCheckpoint(indexer)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		height, err := read()
+		if err != nil {
+			return err
+		}
+		if err := c.SetCheckpoint(indexer, height); err != nil {
+			return err
+		}
+	}
+	return os.Rename(file, file+".imported")
+}
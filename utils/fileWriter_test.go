@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memCheckpoints map[string]uint64
+
+func (m memCheckpoints) GetCheckpoint(indexer string) (uint64, bool, error) {
+	height, ok := m[indexer]
+	return height, ok, nil
+}
+
+func (m memCheckpoints) SetCheckpoint(indexer string, height uint64) error {
+	m[indexer] = height
+	return nil
+}
+
+func TestImportProgress(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	require.NoError(t, os.WriteFile(PullProgressFile, big.NewInt(70000).Bytes(), 0666))
+	require.NoError(t, os.WriteFile(tokenProgressFile, []byte("123456"), 0666))
+
+	// The token indexer already has a checkpoint, which is kept.
+	c := memCheckpoints{"tokens": 200000}
+	require.NoError(t, ImportCurrentHeight(c, "tx"))
+	require.NoError(t, ImportTokenCurrentHeight(c, "tokens"))
+	require.Equal(t, memCheckpoints{"tx": 70000, "tokens": 200000}, c)
+
+	for _, file := range []string{PullProgressFile, tokenProgressFile} {
+		_, err := os.Stat(file)
+		require.True(t, os.IsNotExist(err))
+		_, err = os.Stat(file + ".imported")
+		require.NoError(t, err)
+	}
+
+	// Without the files there is nothing left to import.
+	delete(c, "tx")
+	require.NoError(t, ImportCurrentHeight(c, "tx"))
+	require.Equal(t, memCheckpoints{"tokens": 200000}, c)
+}